@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -19,6 +23,7 @@ import (
 	"google.golang.org/api/option"
 
 	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/retry"
 )
 
 //go:embed credentials.json
@@ -26,25 +31,39 @@ var credentials []byte
 
 type Client struct {
 	oauthCfg *oauth2.Config
+	limiters *retry.Limiters
+	tokens   *tokenSources
 
 	Verbose bool
+	Retry   retry.Policy
+	// Accounts if set saves the tokens when they are refreshed and marks
+	// the accounts whose auth expired.
+	Accounts internal.AccountStorage
 }
 
 func NewClient(credJSON []byte) (*Client, error) {
 	if credJSON == nil {
 		credJSON = credentials
 	}
-	oauthCfg, err := google.ConfigFromJSON(credJSON, "", googleoauth2.UserinfoEmailScope, calendar.CalendarEventsScope)
+	oauthCfg, err := google.ConfigFromJSON(credJSON, "", googleoauth2.UserinfoEmailScope, calendar.CalendarEventsScope, calendar.CalendarCalendarlistReadonlyScope)
 	if err != nil {
 		return nil, fmt.Errorf("google: parsing credentials file: %v", err)
 	}
 
 	return &Client{
 		oauthCfg: oauthCfg,
+		limiters: retry.NewLimiters(requestsPerSecond, requestsBurst),
+		tokens:   newTokenSources(),
+		Retry:    retry.DefaultPolicy,
 	}, nil
 }
 
-const defaultSleep = 5 * time.Second
+// Requests done by each account, Google limits how many requests can be
+// done per minute by each user.
+const (
+	requestsPerSecond = 5
+	requestsBurst     = 10
+)
 
 func (c Client) Events(ctx context.Context, cal *internal.Calendar, from internal.Date) (internal.Iterator, error) {
 	svc, err := c.calendarSvc(ctx, cal)
@@ -116,13 +135,16 @@ func (c Client) events(
 	)
 
 	for {
-		events, err := call.PageToken(nextPageToken).Do()
+		var events *calendar.Events
+		err := c.do(ctx, cal, func() (err error) {
+			events, err = call.PageToken(nextPageToken).Do()
+			return err
+		})
 		if err != nil {
-			if shouldRetry(err) {
-				time.Sleep(defaultSleep)
-				continue
-			}
 			c.logf(cal, "unable to get list of events: %v", err)
+			if syncTokenInvalid(err) {
+				err = fmt.Errorf("%w: %v", internal.ErrInvalidSyncToken, err)
+			}
 			eventCh <- eventOrError{err: err}
 			return
 		}
@@ -131,10 +153,11 @@ func (c Client) events(
 			hasEvents = len(events.Items) > 0
 		}
 
-		for _, item := range events.Items {
+		for i, item := range events.Items {
 			eventCh <- eventOrError{
-				e:        newEvent(item),
-				lastSync: events.NextSyncToken,
+				e:         newEvent(item),
+				lastSync:  events.NextSyncToken,
+				endOfPage: i == len(events.Items)-1,
 			}
 		}
 		nextPageToken = events.NextPageToken
@@ -147,6 +170,29 @@ func (c Client) events(
 	}
 }
 
+func (c Client) Event(ctx context.Context, cal *internal.Calendar, id string) (*internal.Event, error) {
+	svc, err := c.calendarSvc(ctx, cal)
+	if err != nil {
+		return nil, err
+	}
+	var gevent *calendar.Event
+	err = c.do(ctx, cal, func() (err error) {
+		gevent, err = svc.Events.Get(cal.ProviderID, id).Context(ctx).Do()
+		return err
+	})
+	if notFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		c.logf(cal, "unable to get event %s: %v", id, err)
+		return nil, err
+	}
+	if gevent.Status == statusCanceled {
+		return nil, nil
+	}
+	return newEvent(gevent), nil
+}
+
 func (c Client) CreateEvent(ctx context.Context, cal *internal.Calendar, req *internal.Event) (*internal.Event, error) {
 	msg := fmt.Sprintf("creating event: %q on %s... ", req.Summary, req.StartsAt)
 	defer func() {
@@ -158,24 +204,17 @@ func (c Client) CreateEvent(ctx context.Context, cal *internal.Calendar, req *in
 		msg += "❌"
 		return nil, err
 	}
-	prefix := fmt.Sprintf("[%s] ", cal.Name)
-
-	var res *internal.Event
-	for {
-		gevent, err := svc.Events.Insert(cal.ProviderID, newGoogleEvent(prefix, req)).Context(ctx).Do()
-		if err == nil {
-			res = newEvent(gevent)
-			msg += "✅"
-			break
-		}
-		if shouldRetry(err) {
-			time.Sleep(defaultSleep)
-			continue
-		}
+	var gevent *calendar.Event
+	err = c.do(ctx, cal, func() (err error) {
+		gevent, err = svc.Events.Insert(cal.ProviderID, newGoogleEvent(req)).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
 		msg += "❌"
 		return nil, err
 	}
-	return res, nil
+	msg += "✅"
+	return newEvent(gevent), nil
 }
 
 func (c Client) UpdateEvent(ctx context.Context, cal *internal.Calendar, req *internal.Event) error {
@@ -189,21 +228,15 @@ func (c Client) UpdateEvent(ctx context.Context, cal *internal.Calendar, req *in
 		msg += "❌"
 		return err
 	}
-	prefix := fmt.Sprintf("[%s] ", cal.Name)
-
-	for {
-		_, err := svc.Events.Update(cal.ProviderID, req.ID, newGoogleEvent(prefix, req)).Context(ctx).Do()
-		if err == nil {
-			msg += "✅"
-			break
-		}
-		if shouldRetry(err) {
-			time.Sleep(defaultSleep)
-			continue
-		}
+	err = c.do(ctx, cal, func() error {
+		_, err := svc.Events.Update(cal.ProviderID, req.ID, newGoogleEvent(req)).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
 		msg += "❌"
 		return err
 	}
+	msg += "✅"
 	return nil
 }
 
@@ -218,22 +251,51 @@ func (c Client) DeleteEvent(ctx context.Context, cal *internal.Calendar, id stri
 		msg += "❌"
 		return err
 	}
-	for {
-		err = svc.Events.Delete(cal.ProviderID, id).Context(ctx).Do()
-		if err == nil || alreadyDeleted(err) {
-			msg += "✅"
-			break
-		}
-		if shouldRetry(err) {
-			time.Sleep(defaultSleep)
-			continue
-		}
+	err = c.do(ctx, cal, func() error {
+		return svc.Events.Delete(cal.ProviderID, id).Context(ctx).Do()
+	})
+	if err != nil && !alreadyDeleted(err) {
 		msg += "❌"
 		return err
 	}
+	msg += "✅"
 	return nil
 }
 
+// Calendars returns the calendars the account has access to, accounts
+// that logged in before listing was supported must log in again.
+func (c Client) Calendars(ctx context.Context, acc internal.Account) ([]*internal.ProviderCalendar, error) {
+	cal := &internal.Calendar{ID: acc.ID(), Account: acc}
+	svc, err := c.calendarSvc(ctx, cal)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*internal.ProviderCalendar
+	err = c.do(ctx, cal, func() error {
+		res = nil
+		return svc.CalendarList.List().Pages(ctx, func(list *calendar.CalendarList) error {
+			for _, item := range list.Items {
+				res = append(res, &internal.ProviderCalendar{
+					ID:       item.Id,
+					Summary:  item.Summary,
+					Primary:  item.Primary,
+					ReadOnly: item.AccessRole != "owner" && item.AccessRole != "writer",
+				})
+			}
+			return nil
+		})
+	})
+	if errIsReason(err, "insufficientPermissions") {
+		return nil, fmt.Errorf("%s must log in again to list its calendars: %w", acc.ID(), err)
+	}
+	if err != nil {
+		c.logf(cal, "unable to list calendars: %v", err)
+		return nil, err
+	}
+	return res, nil
+}
+
 func (c Client) Email(ctx context.Context, token *oauth2.Token) (string, error) {
 	httpClient := c.oauthCfg.Client(ctx, token)
 	resp, err := httpClient.Get("https://www.googleapis.com/oauth2/v2/userinfo")
@@ -325,26 +387,98 @@ func (c Client) Login(ctx context.Context, fn func(string)) (*oauth2.Token, erro
 }
 
 func (c Client) calendarSvc(ctx context.Context, cal *internal.Calendar) (*calendar.Service, error) {
-	var tok *oauth2.Token
-	err := json.Unmarshal([]byte(cal.Account.Auth), &tok)
-	if err != nil {
-		return nil, err
-	}
-	httpClient, err := c.oauthCfg.Client(ctx, tok), nil
+	src, err := c.tokenSource(cal.Account)
 	if err != nil {
 		return nil, err
 	}
-	return calendar.NewService(ctx, option.WithHTTPClient(httpClient))
+	return calendar.NewService(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, src)))
 }
 
 func (c Client) logf(cal *internal.Calendar, format string, a ...any) {
 	if c.Verbose {
-		internal.Logf(os.Stdout, "google:", cal, format, a...)
+		internal.Logf(os.Stderr, "google:", cal, format, a...)
+	}
+}
+
+// do calls fn respecting the rate limit of the account and retrying it
+// when it fails with a transient error.
+func (c Client) do(ctx context.Context, cal *internal.Calendar, fn func() error) error {
+	limiter := c.limiters.Get(cal.Account.ID())
+	return c.Retry.Do(ctx, func() error {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		return classifyErr(fn())
+	})
+}
+
+// classifyErr wraps err as transient or permanent.
+func classifyErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	// A cancelled context or a refused token won't succeed by trying again,
+	// they're checked first as they're net errors too.
+	var rErr *oauth2.RetrieveError
+	if errors.Is(err, internal.ErrAuthExpired) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &rErr) {
+		return &internal.PermanentError{Err: err}
+	}
+
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		switch {
+		case gErr.Code == http.StatusTooManyRequests,
+			gErr.Code >= http.StatusInternalServerError,
+			errIsReason(err, "rateLimitExceeded"),
+			errIsReason(err, "userRateLimitExceeded"):
+			return &internal.TransientError{
+				Err:        err,
+				RetryAfter: retryAfter(gErr.Header),
+			}
+		}
+		return &internal.PermanentError{Err: err}
+	}
+
+	var netErr net.Error
+	if (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return &internal.TransientError{Err: err}
 	}
+	return err
 }
 
-func shouldRetry(err error) bool {
-	return errIsReason(err, "rateLimitExceeded")
+// retryAfter parses the Retry-After header, which can be in seconds or
+// a date.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func syncTokenInvalid(err error) bool {
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) && gErr.Code == http.StatusGone {
+		return true
+	}
+	return errIsReason(err, "fullSyncRequired")
+}
+
+func notFound(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && (gErr.Code == http.StatusNotFound || gErr.Code == http.StatusGone)
 }
 
 func alreadyDeleted(err error) bool {
@@ -0,0 +1,60 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+func TestClassifyErr(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://www.googleapis.com", Err: err}
+	}
+	dialErr := func(err error) error {
+		return urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)})
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+		permanent bool
+	}{
+		{"too many requests", &googleapi.Error{Code: http.StatusTooManyRequests}, true, false},
+		{"server error", &googleapi.Error{Code: http.StatusBadGateway}, true, false},
+		{"rate limit", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true, false},
+		{"not found", &googleapi.Error{Code: http.StatusNotFound}, false, true},
+		{"auth expired", internal.ErrAuthExpired, false, true},
+		{"token refused", urlErr(&oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest}}), false, true},
+		{"context cancelled", urlErr(context.Canceled), false, true},
+		{"context deadline", urlErr(context.DeadlineExceeded), false, true},
+		{"timeout", urlErr(&net.DNSError{Err: "i/o timeout", IsTimeout: true}), true, false},
+		{"connection refused", dialErr(syscall.ECONNREFUSED), true, false},
+		{"connection reset", dialErr(syscall.ECONNRESET), true, false},
+		{"unexpected eof", urlErr(io.ErrUnexpectedEOF), true, false},
+		{"unknown host", urlErr(&net.DNSError{Err: "no such host", IsNotFound: true}), false, false},
+		{"other", errors.New("other"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyErr(tt.err)
+			if got := internal.IsTransient(err); got != tt.transient {
+				t.Errorf("IsTransient() = %v, want %v", got, tt.transient)
+			}
+			if got := internal.IsPermanent(err); got != tt.permanent {
+				t.Errorf("IsPermanent() = %v, want %v", got, tt.permanent)
+			}
+		})
+	}
+}
@@ -8,9 +8,10 @@ import (
 )
 
 type eventOrError struct {
-	e        *internal.Event
-	lastSync string
-	err      error
+	e         *internal.Event
+	lastSync  string
+	endOfPage bool
+	err       error
 }
 
 type eventIterator struct {
@@ -48,11 +49,19 @@ func (it *eventIterator) LastSync() string {
 	return it.lastSync
 }
 
+func (it *eventIterator) EndOfPage() bool {
+	return it.current.endOfPage
+}
+
 func (it *eventIterator) Err() error {
 	return it.current.err
 }
 
-const statusCanceled = "cancelled"
+const (
+	statusCanceled          = "cancelled"
+	transparencyTransparent = "transparent"
+	transparencyOpaque      = "opaque"
+)
 
 func newEvent(event *calendar.Event) *internal.Event {
 	if event.Status == statusCanceled {
@@ -71,8 +80,22 @@ func newEvent(event *calendar.Event) *internal.Event {
 
 	startsAt, _ := time.Parse(time.RFC3339, event.Start.DateTime)
 	endsAt, _ := time.Parse(time.RFC3339, event.End.DateTime)
+
+	var origin *internal.Origin
+	if props := event.ExtendedProperties; props != nil && props.Private[originCalendarKey] != "" {
+		origin = &internal.Origin{
+			CalendarID:    props.Private[originCalendarKey],
+			EventID:       props.Private[originEventKey],
+			PassedThrough: props.Private[originPassedThroughKey] != "",
+		}
+	}
+	var organizer string
+	if event.Organizer != nil {
+		organizer = event.Organizer.Email
+	}
 	return &internal.Event{
 		ID:             event.Id,
+		ICalUID:        event.ICalUID,
 		Type:           internal.EventType(event.EventType),
 		Summary:        event.Summary,
 		Description:    event.Description,
@@ -80,21 +103,49 @@ func newEvent(event *calendar.Event) *internal.Event {
 		EndsAt:         endsAt,
 		CreatedBy:      event.Creator.Email,
 		CreatedByMe:    event.Creator.Self,
+		Organizer:      organizer,
 		ResponseStatus: responseStatus,
 		NumAttendees:   len(event.Attendees),
+		Transparent:    event.Transparency == transparencyTransparent,
+		ColorID:        event.ColorId,
+		Origin:         origin,
 	}
 }
 
 var eventTypeFromGmail internal.EventType = "fromGmail"
 
-func newGoogleEvent(prefix string, event *internal.Event) *calendar.Event {
+// Keys of the private extended properties used to mark the events
+// created by us.
+const (
+	originCalendarKey      = "synccalendarCalendarId"
+	originEventKey         = "synccalendarEventId"
+	originPassedThroughKey = "synccalendarPassedThrough"
+)
+
+func newGoogleEvent(event *internal.Event) *calendar.Event {
 	eventType := event.Type
 	if event.Type == eventTypeFromGmail {
 		eventType = internal.EventTypeDefault
 	}
+	var props *calendar.EventExtendedProperties
+	if event.Origin != nil {
+		props = &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				originCalendarKey: event.Origin.CalendarID,
+				originEventKey:    event.Origin.EventID,
+			},
+		}
+		if event.Origin.PassedThrough {
+			props.Private[originPassedThroughKey] = "true"
+		}
+	}
+	transparency := transparencyOpaque
+	if event.Transparent {
+		transparency = transparencyTransparent
+	}
 	return &calendar.Event{
 		EventType:   eventType.String(),
-		Summary:     prefix + event.Summary,
+		Summary:     event.Summary,
 		Description: event.Description,
 		Start: &calendar.EventDateTime{
 			DateTime: event.StartsAt.Format(time.RFC3339),
@@ -105,5 +156,8 @@ func newGoogleEvent(prefix string, event *internal.Event) *calendar.Event {
 		Reminders: &calendar.EventReminders{
 			UseDefault: true,
 		},
+		ExtendedProperties: props,
+		Transparency:       transparency,
+		ColorId:            event.ColorID,
 	}
 }
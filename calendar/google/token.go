@@ -0,0 +1,121 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/oauth2"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// tokenSources keeps one token source per account, this way the token is
+// refreshed only once and all requests of the account can stop as soon
+// as its auth expires.
+type tokenSources struct {
+	mu      sync.Mutex
+	sources map[string]*tokenSource
+}
+
+func newTokenSources() *tokenSources {
+	return &tokenSources{
+		sources: make(map[string]*tokenSource),
+	}
+}
+
+// tokenSource saves the token every time it's refreshed and marks the
+// account when its auth was revoked or expired.
+type tokenSource struct {
+	mu       sync.Mutex
+	base     oauth2.TokenSource
+	account  internal.Account
+	accounts internal.AccountStorage
+	last     string
+	err      error
+}
+
+func (c Client) tokenSource(acc internal.Account) (oauth2.TokenSource, error) {
+	c.tokens.mu.Lock()
+	defer c.tokens.mu.Unlock()
+
+	if src, ok := c.tokens.sources[acc.ID()]; ok {
+		return src, nil
+	}
+	if acc.NeedsReauth() {
+		return nil, fmt.Errorf("%w: %s", internal.ErrAuthExpired, acc.AuthError)
+	}
+
+	var tok *oauth2.Token
+	if err := json.Unmarshal([]byte(acc.Auth), &tok); err != nil {
+		return nil, err
+	}
+	src := &tokenSource{
+		// The token is refreshed in the background of the requests,
+		// it can't be bound to the context of any of them.
+		base:     c.oauthCfg.TokenSource(context.Background(), tok),
+		account:  acc,
+		accounts: c.Accounts,
+		last:     tok.AccessToken,
+	}
+	c.tokens.sources[acc.ID()] = src
+	return src, nil
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	tok, err := s.base.Token()
+	if invalidGrant(err) {
+		s.err = fmt.Errorf("%w: %v", internal.ErrAuthExpired, err)
+		s.expire(err)
+		return nil, s.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.AccessToken != s.last {
+		s.last = tok.AccessToken
+		s.save(tok)
+	}
+	return tok, nil
+}
+
+func (s *tokenSource) save(tok *oauth2.Token) {
+	if s.accounts == nil {
+		return
+	}
+	auth, err := json.Marshal(tok)
+	if err == nil {
+		s.account.Auth = string(auth)
+		err = s.accounts.SaveAuth(context.Background(), &s.account)
+	}
+	if err != nil {
+		internal.Logf(os.Stderr, "google:", nil, "unable to save token of %s: %v", s.account.ID(), err)
+	}
+}
+
+func (s *tokenSource) expire(reason error) {
+	internal.Logf(os.Stderr, "google:", nil, "%s needs to authenticate again: %v", s.account.ID(), reason)
+	if s.accounts == nil {
+		return
+	}
+	err := s.accounts.SetAuthStatus(context.Background(), &s.account, internal.AuthExpired, reason.Error())
+	if err != nil {
+		internal.Logf(os.Stderr, "google:", nil, "unable to save auth status of %s: %v", s.account.ID(), err)
+	}
+}
+
+// invalidGrant checks if the refresh token was revoked or expired.
+func invalidGrant(err error) bool {
+	var rErr *oauth2.RetrieveError
+	return errors.As(err, &rErr) && rErr.ErrorCode == "invalid_grant"
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/sqlite"
+	"github.com/guilherme-santos/synccalendar/internal/syncer"
+)
+
+var AccountsCommand = _accountsCommand{
+	Name:        "accounts",
+	Description: "List, remove and log in again with the accounts",
+}
+
+type _accountsCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _accountsCommand) Run(ctx context.Context, dbFilename string, verbose bool, args []string) error {
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s <command>:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Commands:\n")
+		fmt.Fprintf(w, "  list                   List the accounts with their auth and calendars\n")
+		fmt.Fprintf(w, "  remove <account id>    Remove an account with its calendars and links\n")
+		fmt.Fprintf(w, "  reauth <account id>    Log in again with an account\n")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Use \"%s %s <command> --help\" for the options of a command.\n", os.Args[0], fs.Name())
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		return s.list(ctx, storage, args)
+	case "remove":
+		return s.remove(ctx, storage, verbose, args)
+	case "reauth":
+		return s.reauth(ctx, storage, verbose, args)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func (s _accountsCommand) list(ctx context.Context, storage *sqlite.Storage, args []string) error {
+	fs := subcommandFlagSet(s.Name, "list", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	accounts, err := storage.Accounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tPLATFORM\tEMAIL\tAUTH\tCALENDARS\tERROR")
+	for _, acc := range accounts {
+		cals, err := storage.AccountCalendars(ctx, acc)
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(cals))
+		for i, cal := range cals {
+			names[i] = cal.Name
+		}
+		calendars := strings.Join(names, ", ")
+		if calendars == "" {
+			calendars = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", acc.ID(), acc.Platform, acc.Name, acc.AuthStatus, calendars, acc.AuthError)
+	}
+	return w.Flush()
+}
+
+func (s _accountsCommand) remove(ctx context.Context, storage *sqlite.Storage, verbose bool, args []string) error {
+	var purge bool
+
+	fs := subcommandFlagSet(s.Name, "remove", "<account id>")
+	fs.BoolVar(&purge, "purge", false, "also delete the events mirrored from the account in the calendars of other accounts")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	acc, err := findAccount(ctx, storage, args[0])
+	if err != nil {
+		return err
+	}
+
+	if purge {
+		links, err := mirroredLinks(ctx, storage, acc)
+		if err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			mux, err := newMux(verbose, storage)
+			if err != nil {
+				return err
+			}
+			report := &syncer.SyncReport{}
+			syncer := syncer.New(flag.CommandLine.Output(), mux, storage)
+			run, err := startRun(ctx, storage, syncer)
+			if err != nil {
+				return err
+			}
+			report.RunID = run.ID
+
+			for _, link := range links {
+				lr, uerr := syncer.Unlink(ctx, link)
+				report.Links = append(report.Links, lr)
+				if uerr != nil {
+					err = errors.New("some events couldn't be deleted, the account was kept")
+				}
+			}
+			finishRun(ctx, storage, run, report, err)
+			printReport(report)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := storage.DeleteAccount(ctx, acc); err != nil {
+		return err
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "Account %s removed\n", acc.ID())
+	return nil
+}
+
+// mirroredLinks returns the links mirroring the calendars of acc into the
+// calendars of other accounts.
+func mirroredLinks(ctx context.Context, storage *sqlite.Storage, acc *internal.Account) ([]*internal.Link, error) {
+	dstcals, err := storage.DestinationCalendars(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res []*internal.Link
+	for _, dst := range dstcals {
+		if dst.Account.ID() == acc.ID() {
+			continue
+		}
+		links, err := storage.Links(ctx, dst)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			if link.Source.Account.ID() == acc.ID() {
+				res = append(res, link)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s _accountsCommand) reauth(ctx context.Context, storage *sqlite.Storage, verbose bool, args []string) error {
+	fs := subcommandFlagSet(s.Name, "reauth", "<account id>")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	acc, err := findAccount(ctx, storage, args[0])
+	if err != nil {
+		return err
+	}
+
+	provider, err := newConfigureProvider(acc.Platform, verbose, storage)
+	if err != nil {
+		return err
+	}
+	w := flag.CommandLine.Output()
+	c := configurer{
+		storage:  storage,
+		provider: provider,
+		platform: acc.Platform,
+		p:        &prompter{w: w, r: bufio.NewReader(os.Stdin)},
+	}
+	if _, err := c.login(ctx, acc.Name); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Account %s logged in again\n", acc.ID())
+	return nil
+}
+
+// findAccount returns the account with the id given as argument.
+func findAccount(ctx context.Context, storage *sqlite.Storage, id string) (*internal.Account, error) {
+	acc, err := storage.Account(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, fmt.Errorf("account %s not found", id)
+	}
+	return acc, nil
+}
@@ -1,10 +1,26 @@
 package main
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/sqlite"
+	"github.com/guilherme-santos/synccalendar/internal/syncer"
+)
 
 var CalendarCommand = _calendarCommand{
-	Name:        "sync",
-	Description: "Sync calendars previosly configured",
+	Name:        "calendar",
+	Description: "List, link, rename, pause and resume the calendars",
 }
 
 type _calendarCommand struct {
@@ -12,6 +28,341 @@ type _calendarCommand struct {
 	Description string
 }
 
-func (s _calendarCommand) Run(ctx context.Context, args []string) error {
+func (s _calendarCommand) Run(ctx context.Context, dbFilename string, verbose bool, args []string) error {
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s <command>:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Commands:\n")
+		fmt.Fprintf(w, "  list                            List the links and the destination calendars\n")
+		fmt.Fprintf(w, "  show <link id>                  Show the details of a link\n")
+		fmt.Fprintf(w, "  link <source> <destination>     Link two calendars, or change the options of their link\n")
+		fmt.Fprintf(w, "  unlink <link id>                Remove a link and the events mirrored through it\n")
+		fmt.Fprintf(w, "  rename <calendar id> <name>     Rename a calendar, updating the summary of its mirrors\n")
+		fmt.Fprintf(w, "  pause <link id>                 Stop syncing a link\n")
+		fmt.Fprintf(w, "  resume <link id>                Sync a paused link again\n")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Use \"%s %s <command> --help\" for the options of a command.\n", os.Args[0], fs.Name())
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		return s.list(ctx, storage, args)
+	case "show":
+		return s.show(ctx, storage, args)
+	case "link":
+		return s.link(ctx, storage, args)
+	case "unlink":
+		return s.unlink(ctx, storage, verbose, args)
+	case "rename":
+		return s.rename(ctx, storage, verbose, args)
+	case "pause":
+		return s.setStatus(ctx, storage, cmd, internal.LinkPaused, args)
+	case "resume":
+		return s.setStatus(ctx, storage, cmd, internal.LinkActive, args)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func (s _calendarCommand) list(ctx context.Context, storage *sqlite.Storage, args []string) error {
+	fs := subcommandFlagSet(s.Name, "list", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	dstcals, err := storage.DestinationCalendars(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tSOURCE\tDESTINATION\tSTATUS\tOPTIONS")
+	for _, dst := range dstcals {
+		links, err := storage.Links(ctx, dst)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			opts, err := json.Marshal(link.Options)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", link.ID, link.Source, dst, link.Status, opts)
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "DESTINATION\tPROVIDER ID\tMODE")
+	for _, dst := range dstcals {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", dst, dst.ProviderID, calendarMode(dst.Mode))
+	}
+	return w.Flush()
+}
+
+func (s _calendarCommand) show(ctx context.Context, storage *sqlite.Storage, args []string) error {
+	fs := subcommandFlagSet(s.Name, "show", "<link id>")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	link, err := findLink(ctx, storage, args[0])
+	if err != nil {
+		return err
+	}
+	stats, err := storage.LinkStats(ctx, link)
+	if err != nil {
+		return err
+	}
+	opts, err := json.Marshal(link.Options)
+	if err != nil {
+		return err
+	}
+
+	lastSync := "never"
+	if link.LastSync != "" {
+		lastSync = "saved"
+	}
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Link:\t%d\n", link.ID)
+	fmt.Fprintf(w, "Status:\t%s\n", link.Status)
+	fmt.Fprintf(w, "Source:\t%s (%s, auth %s)\n", link.Source, link.Source.ProviderID, link.Source.Account.AuthStatus)
+	fmt.Fprintf(w, "Destination:\t%s (%s, auth %s)\n", link.Destination, link.Destination.ProviderID, link.Destination.Account.AuthStatus)
+	fmt.Fprintf(w, "Mode:\t%s\n", calendarMode(link.Destination.Mode))
+	fmt.Fprintf(w, "Sync token:\t%s\n", lastSync)
+	if link.Destination.Mode == internal.CalendarModeBusy {
+		fmt.Fprintf(w, "Busy events:\t%d\n", stats.BusySources)
+	} else {
+		fmt.Fprintf(w, "Mirrored events:\t%d\n", stats.Events)
+	}
+	fmt.Fprintf(w, "Retries:\t%d pending, %d dead\n", stats.PendingRetries, stats.DeadRetries)
+	fmt.Fprintf(w, "Options:\t%s\n", opts)
+	return w.Flush()
+}
+
+func (s _calendarCommand) link(ctx context.Context, storage *sqlite.Storage, args []string) error {
+	var opts internal.LinkOptions
+
+	fs := subcommandFlagSet(s.Name, "link", "<source calendar id> <destination calendar id>")
+	linkOptionsVar(fs, &opts)
+	args, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	var cals [2]*internal.Calendar
+	for i, id := range args {
+		cals[i], err = storage.Calendar(ctx, id)
+		if err != nil {
+			return err
+		}
+		if cals[i] == nil {
+			return fmt.Errorf("calendar %s not found, add it with the configure command", id)
+		}
+	}
+	if cals[0].ID == cals[1].ID {
+		return errors.New("a calendar can't be linked to itself")
+	}
+
+	link := &internal.Link{
+		Source:      cals[0],
+		Destination: cals[1],
+		Options:     opts,
+	}
+	// Changing the options of a paused link doesn't resume it.
+	links, err := storage.Links(ctx, cals[1])
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		if l.Source.ID == link.Source.ID {
+			link.Status = l.Status
+		}
+	}
+	if err := storage.LinkCalendar(ctx, link); err != nil {
+		return fmt.Errorf("linking calendars: %w", err)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "Link %d: %s\n", link.ID, link)
+	return nil
+}
+
+func (s _calendarCommand) unlink(ctx context.Context, storage *sqlite.Storage, verbose bool, args []string) error {
+	var keepEvents bool
+
+	fs := subcommandFlagSet(s.Name, "unlink", "<link id>")
+	fs.BoolVar(&keepEvents, "keep-events", false, "keep the events mirrored through the link in the destination calendar")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	link, err := findLink(ctx, storage, args[0])
+	if err != nil {
+		return err
+	}
+
+	if !keepEvents {
+		mux, err := newMux(verbose, storage)
+		if err != nil {
+			return err
+		}
+		report := &syncer.SyncReport{}
+		syncer := syncer.New(flag.CommandLine.Output(), mux, storage)
+		run, err := startRun(ctx, storage, syncer)
+		if err != nil {
+			return err
+		}
+		report.RunID = run.ID
+
+		lr, err := syncer.Unlink(ctx, link)
+		report.Links = append(report.Links, lr)
+		finishRun(ctx, storage, run, report, err)
+		printReport(report)
+		if err != nil {
+			return fmt.Errorf("some events couldn't be deleted, the link was kept: %w", err)
+		}
+	}
+	if err := storage.DeleteLink(ctx, link); err != nil {
+		return err
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "Link %d removed\n", link.ID)
 	return nil
 }
+
+func (s _calendarCommand) rename(ctx context.Context, storage *sqlite.Storage, verbose bool, args []string) error {
+	fs := subcommandFlagSet(s.Name, "rename", "<calendar id> <name>")
+	args, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	id, name := args[0], args[1]
+	if name == "" || strings.Contains(name, "/") {
+		return errors.New("name can't be empty or contain /")
+	}
+
+	cal, err := storage.Calendar(ctx, id)
+	if err != nil {
+		return err
+	}
+	if cal == nil {
+		return fmt.Errorf("calendar %s not found", id)
+	}
+	newID := cal.Account.ID() + "/" + name
+	if other, err := storage.Calendar(ctx, newID); err != nil || other != nil {
+		if err == nil {
+			err = fmt.Errorf("calendar %s already exists", newID)
+		}
+		return err
+	}
+	if err := storage.RenameCalendar(ctx, cal, name); err != nil {
+		return err
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "Calendar %s renamed to %s\n", id, newID)
+
+	// The summary of the mirrors has the name of the destination and
+	// they point to the id of their source, the destinations linked to
+	// the calendar are rendered again.
+	dstcals, err := storage.DestinationCalendars(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var affected []*internal.Calendar
+	for _, dst := range dstcals {
+		if dst.ID == newID {
+			affected = append(affected, dst)
+			continue
+		}
+		links, err := storage.Links(ctx, dst)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			if link.Source.ID == newID {
+				affected = append(affected, dst)
+				break
+			}
+		}
+	}
+	if len(affected) == 0 {
+		return nil
+	}
+
+	mux, err := newMux(verbose, storage)
+	if err != nil {
+		return err
+	}
+	report := &syncer.SyncReport{}
+	syncer := syncer.New(flag.CommandLine.Output(), mux, storage)
+	run, err := startRun(ctx, storage, syncer)
+	if err != nil {
+		return err
+	}
+	report.RunID = run.ID
+
+	for _, dst := range affected {
+		links, rerr := syncer.Rerender(ctx, dst)
+		report.Links = append(report.Links, links...)
+		if rerr != nil {
+			err = errors.New("some events couldn't be updated, they are updated when their source changes")
+		}
+	}
+	finishRun(ctx, storage, run, report, err)
+	printReport(report)
+	return err
+}
+
+func (s _calendarCommand) setStatus(ctx context.Context, storage *sqlite.Storage, cmd string, status internal.LinkStatus, args []string) error {
+	fs := subcommandFlagSet(s.Name, cmd, "<link id>")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	link, err := findLink(ctx, storage, args[0])
+	if err != nil {
+		return err
+	}
+	if err := storage.SetLinkStatus(ctx, link, status); err != nil {
+		return err
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "Link %d is %s\n", link.ID, status)
+	return nil
+}
+
+// findLink returns the link with the id given as argument.
+func findLink(ctx context.Context, storage *sqlite.Storage, arg string) (*internal.Link, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link id %q", arg)
+	}
+	link, err := storage.Link(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if link == nil {
+		return nil, fmt.Errorf("link %d not found", id)
+	}
+	return link, nil
+}
+
+func calendarMode(mode internal.CalendarMode) string {
+	if mode == internal.CalendarModeDefault {
+		return "default"
+	}
+	return mode.String()
+}
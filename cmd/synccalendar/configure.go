@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/oauth2"
@@ -15,10 +20,7 @@ import (
 	"github.com/guilherme-santos/synccalendar/internal/sqlite"
 )
 
-const (
-	googleProvider = "google"
-	primaryEmail   = "guilherme@giox.com.br"
-)
+const googleProvider = "google"
 
 var ConfigureCommand = _configureCommand{
 	Name:        "configure",
@@ -30,52 +32,265 @@ type _configureCommand struct {
 	Description string
 }
 
+// configureProvider is what configure needs from a provider to log in and
+// choose the calendars.
+type configureProvider interface {
+	Login(ctx context.Context, fn func(string)) (*oauth2.Token, error)
+	Email(ctx context.Context, token *oauth2.Token) (string, error)
+	Calendars(ctx context.Context, acc internal.Account) ([]*internal.ProviderCalendar, error)
+}
+
 func (s _configureCommand) Run(ctx context.Context, dbFilename string, verbose bool, args []string) error {
-	db, err := sql.Open(sqlite.DriverName, dbFilename)
+	storage, err := setupStorage(ctx, dbFilename)
 	if err != nil {
 		return err
 	}
-	storage := sqlite.NewStorage(db)
+
+	var (
+		dedup          bool
+		busy           bool
+		opts           internal.LinkOptions
+		providerName   string
+		srcAccountName string
+		srcCalendarID  string
+		dstAccountName string
+		dstCalendarID  string
+		name           string
+		nonInteractive bool
+	)
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "What isn't given through the options is asked, unless -non-interactive is set.\n")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&dedup, "dedup", false, "merge the same event received from several sources in the destination calendar")
+	fs.BoolVar(&busy, "busy", false, "only create busy blocks in the destination calendar, merging overlapping events of all sources")
+	fs.StringVar(&providerName, "provider", "", "calendar provider of the accounts: google")
+	fs.StringVar(&srcAccountName, "source-account", "", "email of the source account, you log in with it when it isn't saved yet")
+	fs.StringVar(&srcCalendarID, "source-calendar", "", "id of the source calendar on the provider (default primary when -non-interactive)")
+	fs.StringVar(&dstAccountName, "destination-account", "", "email of the destination account, it must be saved already")
+	fs.StringVar(&dstCalendarID, "destination-calendar", "", "id of the destination calendar on the provider")
+	fs.StringVar(&name, "name", "", "name of the link, given to both calendars")
+	fs.BoolVar(&nonInteractive, "non-interactive", false, "fail instead of asking what is missing")
+	linkOptionsVar(fs, &opts)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dedup && busy {
+		return fmt.Errorf("-dedup and -busy cannot be used together")
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("-name cannot contain /")
+	}
 
 	w := flag.CommandLine.Output()
+	p := &prompter{w: w, r: bufio.NewReader(os.Stdin), disabled: nonInteractive}
 
-	fmt.Fprintf(w, "Select a calendar provider:\n")
-	fmt.Fprintf(w, "1. Google\n")
+	if providerName == "" {
+		if _, err := p.choose("a calendar provider", "provider", []string{"Google"}); err != nil {
+			return err
+		}
+		providerName = googleProvider
+	}
+	provider, err := newConfigureProvider(providerName, verbose, storage)
+	if err != nil {
+		return err
+	}
 
-	var providerChoice int
-	fmt.Scanf("%d", &providerChoice)
+	c := configurer{storage: storage, provider: provider, platform: providerName, p: p}
 
-	var providerName string
-	var provider interface {
-		Login(ctx context.Context, fn func(string)) (*oauth2.Token, error)
-		Email(ctx context.Context, token *oauth2.Token) (string, error)
+	srcAccount, err := c.account(ctx, "the source account", "source-account", srcAccountName, true)
+	if err != nil {
+		return err
 	}
-	switch providerChoice {
-	case 1:
-		providerName = googleProvider
+	if srcCalendarID == "" && nonInteractive {
+		srcCalendarID = "primary"
+	}
+	srcCalendarID, err = c.calendar(ctx, "the source calendar", "source-calendar", srcAccount, srcCalendarID, false)
+	if err != nil {
+		return err
+	}
+
+	dstAccount, err := c.account(ctx, "the destination account", "destination-account", dstAccountName, false)
+	if err != nil {
+		return err
+	}
+	dstCalendarID, err = c.calendar(ctx, "the destination calendar", "destination-calendar", dstAccount, dstCalendarID, true)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name, err = p.ask("Name of the link", "name")
+		if err != nil {
+			return err
+		}
+		if strings.Contains(name, "/") {
+			return fmt.Errorf("name cannot contain /")
+		}
+	}
+
+	if srcAccount.ID() == dstAccount.ID() {
+		// Calendars are named after the link, both would be the same.
+		return fmt.Errorf("the source and destination accounts must be different")
+	}
+
+	sourceCalendar := &internal.Calendar{
+		ID:         srcAccount.ID() + "/" + name,
+		Name:       name,
+		ProviderID: srcCalendarID,
+		Account:    *srcAccount,
+	}
+	destinationCalendar := &internal.Calendar{
+		ID:         dstAccount.ID() + "/" + name,
+		Name:       name,
+		ProviderID: dstCalendarID,
+		Account:    *dstAccount,
+	}
+
+	err = storage.LinkCalendar(ctx, &internal.Link{
+		Source:      sourceCalendar,
+		Destination: destinationCalendar,
+		Options:     opts,
+	})
+	if err != nil {
+		return fmt.Errorf("linking calendars: %v", err)
+	}
+	mode := internal.CalendarModeDefault
+	switch {
+	case dedup:
+		mode = internal.CalendarModeDedup
+	case busy:
+		mode = internal.CalendarModeBusy
+	}
+	if mode != internal.CalendarModeDefault {
+		err = storage.SetCalendarMode(ctx, destinationCalendar, mode)
+		if err != nil {
+			return fmt.Errorf("setting calendar mode: %v", err)
+		}
+	}
+	fmt.Fprintf(w, "Linked %s to %s\n", sourceCalendar, destinationCalendar)
+	return nil
+}
+
+func newConfigureProvider(platform string, verbose bool, storage *sqlite.Storage) (configureProvider, error) {
+	switch platform {
+	case googleProvider:
 		googleCal, err := google.NewClient(nil)
 		if err != nil {
-			return fmt.Errorf("creating Google client: %v", err)
+			return nil, fmt.Errorf("creating Google client: %v", err)
 		}
 		googleCal.Verbose = verbose
-		provider = googleCal
+		googleCal.Accounts = storage
+		return googleCal, nil
 	default:
-		return fmt.Errorf("invalid choice: %d", providerChoice)
+		return nil, fmt.Errorf("invalid provider %q", platform)
 	}
+}
 
-	authToken, err := provider.Login(ctx, func(authURL string) {
+// configurer chooses the accounts and calendars of a new link.
+type configurer struct {
+	storage  *sqlite.Storage
+	provider configureProvider
+	platform string
+	p        *prompter
+}
+
+// loginChoice is offered together with the saved accounts.
+const loginChoice = "Log in with another account"
+
+// account returns the saved account called name. When login is set and
+// the account isn't saved yet, or must log in again, the user logs in
+// with it. Without a name one of the saved accounts is chosen.
+func (c configurer) account(ctx context.Context, title, flagName, name string, login bool) (*internal.Account, error) {
+	if name != "" {
+		acc, err := c.storage.Account(ctx, c.platform+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		if acc != nil && !(login && acc.NeedsReauth()) {
+			return acc, nil
+		}
+		if !login {
+			return nil, fmt.Errorf("account %s/%s not found, log in with it as a source account first", c.platform, name)
+		}
+		// Logging in asks for the code given by the provider.
+		if c.p.disabled && acc != nil {
+			return nil, fmt.Errorf("account %s needs to log in again, run accounts reauth", acc.ID())
+		}
+		if c.p.disabled {
+			return nil, fmt.Errorf("account %s/%s isn't saved, run configure interactively or accounts reauth", c.platform, name)
+		}
+		return c.login(ctx, name)
+	}
+
+	accounts, err := c.storage.Accounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		names   []string
+		options []string
+	)
+	for _, acc := range accounts {
+		if acc.Platform != c.platform {
+			continue
+		}
+		option := acc.Name
+		if acc.NeedsReauth() {
+			option += " (needs to log in again)"
+		}
+		names = append(names, acc.Name)
+		options = append(options, option)
+	}
+	if login {
+		options = append(options, loginChoice)
+	}
+	if len(options) == 0 {
+		return nil, fmt.Errorf("there are no %s accounts, log in with a source account first", c.platform)
+	}
+
+	i, err := c.p.choose(title, flagName, options)
+	if err != nil {
+		return nil, err
+	}
+	if i == len(names) {
+		return c.login(ctx, "")
+	}
+	acc, err := c.storage.Account(ctx, c.platform+"/"+names[i])
+	if err == nil && login && acc.NeedsReauth() {
+		return c.login(ctx, acc.Name)
+	}
+	return acc, err
+}
+
+// login saves the account the user logs in with, when name is given it
+// must be the account used.
+func (c configurer) login(ctx context.Context, name string) (*internal.Account, error) {
+	w := c.p.w
+	authToken, err := c.provider.Login(ctx, func(authURL string) {
 		fmt.Fprintf(w, "Go to the following link in your browser\n%s\n", authURL)
 	})
 	if err != nil {
-		return fmt.Errorf("google: logging in: %v", err)
+		return nil, fmt.Errorf("%s: logging in: %v", c.platform, err)
 	}
-	userEmail, err := provider.Email(ctx, authToken)
+	userEmail, err := c.provider.Email(ctx, authToken)
 	if err != nil {
-		return fmt.Errorf("google: getting email: %v", err)
+		return nil, fmt.Errorf("%s: getting email: %v", c.platform, err)
+	}
+	if name != "" && userEmail != name {
+		return nil, fmt.Errorf("logged in as %s instead of %s", userEmail, name)
 	}
 
-	acc := internal.Account{
-		Platform: providerName,
+	acc := &internal.Account{
+		Platform: c.platform,
 		Name:     userEmail,
 		Auth: func() string {
 			v, _ := json.Marshal(authToken)
@@ -83,37 +298,102 @@ func (s _configureCommand) Run(ctx context.Context, dbFilename string, verbose b
 		}(),
 	}
 	fmt.Fprintf(w, "Saving account %q for %q provider...\n", acc.Name, acc.Platform)
-	err = storage.AddAccount(ctx, &acc)
+	if err := c.storage.AddAccount(ctx, acc); err != nil {
+		return nil, fmt.Errorf("saving account: %v", err)
+	}
+	return acc, nil
+}
+
+// calendar returns id, or the id of the calendar of acc chosen by the
+// user. Destination calendars must be writable.
+func (c configurer) calendar(ctx context.Context, title, flagName string, acc *internal.Account, id string, writable bool) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+	if c.p.disabled {
+		return "", fmt.Errorf("-%s is required", flagName)
+	}
+
+	cals, err := c.provider.Calendars(ctx, *acc)
 	if err != nil {
-		return fmt.Errorf("saving account: %v", err)
+		return "", fmt.Errorf("listing calendars of %s: %w", acc.ID(), err)
+	}
+	var (
+		ids     []string
+		options []string
+	)
+	for _, cal := range cals {
+		if writable && cal.ReadOnly {
+			continue
+		}
+		option := fmt.Sprintf("%s (%s)", cal.Summary, cal.ID)
+		if cal.Primary {
+			option += " [primary]"
+		}
+		ids = append(ids, cal.ID)
+		options = append(options, option)
+	}
+	if len(options) == 0 {
+		return "", fmt.Errorf("%s has no calendars to choose from", acc.ID())
 	}
 
-	destinationCalendar := &internal.Calendar{
-		ID: googleProvider + "/" + primaryEmail,
-		// Name
-		// ProviderID
-		Account: internal.Account{
-			Platform: googleProvider,
-			Name:     primaryEmail,
-		},
+	i, err := c.p.choose(title, flagName, options)
+	if err != nil {
+		return "", err
 	}
+	return ids[i], nil
+}
 
-	fmt.Fprint(w, "Name of the new calendar: ")
-	fmt.Scanln(&destinationCalendar.Name)
-	fmt.Fprintf(w, "Calendar ID of the Destination on %q: ", googleProvider)
-	fmt.Scanln(&destinationCalendar.ProviderID)
+// prompter asks the user for what wasn't given through the flags.
+type prompter struct {
+	w io.Writer
+	r *bufio.Reader
+	// disabled makes it fail asking for the flag instead.
+	disabled bool
+}
 
-	sourceCalendar := &internal.Calendar{
-		ID:   acc.ID(),
-		Name: destinationCalendar.Name,
-		// We only sync with the primary calendar.
-		ProviderID: "primary",
-		Account:    acc,
+// choose returns the index of the option chosen.
+func (p *prompter) choose(title, flagName string, options []string) (int, error) {
+	if p.disabled {
+		return 0, fmt.Errorf("-%s is required", flagName)
+	}
+	fmt.Fprintf(p.w, "Select %s:\n", title)
+	for i, option := range options {
+		fmt.Fprintf(p.w, "%d. %s\n", i+1, option)
+	}
+	for {
+		line, err := p.r.ReadString('\n')
+		n, convErr := strconv.Atoi(strings.TrimSpace(line))
+		if convErr == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		if err != nil {
+			return 0, readError(err)
+		}
+		fmt.Fprintf(p.w, "Invalid choice, enter a number from 1 to %d: ", len(options))
 	}
+}
 
-	err = storage.LinkCalendar(ctx, sourceCalendar, destinationCalendar)
-	if err != nil {
-		return fmt.Errorf("linking calendars: %v", err)
+// ask returns the answer to the question, it can't be empty.
+func (p *prompter) ask(question, flagName string) (string, error) {
+	if p.disabled {
+		return "", fmt.Errorf("-%s is required", flagName)
 	}
-	return nil
+	for {
+		fmt.Fprintf(p.w, "%s: ", question)
+		line, err := p.r.ReadString('\n')
+		if answer := strings.TrimSpace(line); answer != "" {
+			return answer, nil
+		}
+		if err != nil {
+			return "", readError(err)
+		}
+	}
+}
+
+func readError(err error) error {
+	if errors.Is(err, io.EOF) {
+		return errors.New("no answer given, use -non-interactive to get which options are missing")
+	}
+	return err
 }
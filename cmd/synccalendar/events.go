@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+var EventsCommand = _eventsCommand{
+	Name:        "events",
+	Description: "Search the events of the source calendars saved by the last syncs, without calling the providers",
+}
+
+type _eventsCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _eventsCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	var (
+		calIDs   Strings
+		from, to internal.Date
+		q        internal.EventQuery
+	)
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&calIDs, "calendar-id", "calendar-id of the source calendar to search")
+	fs.Var(&from, "from", "only events after this date, format: "+internal.DateFormat)
+	fs.Var(&to, "to", "only events before this date, format: "+internal.DateFormat)
+	fs.StringVar(&q.Text, "q", "", "text to search in the summary and description")
+	fs.IntVar(&q.Limit, "n", 100, "maximum number of events, 0 for all")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	q.CalendarIDs = calIDs
+	q.From = from.Time
+	if !to.IsZero() {
+		// Include the whole day.
+		q.To = to.AddDate(0, 0, 1).Time
+	}
+	events, err := storage.SourceEvents(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CALENDAR\tEVENT\tSTARTS AT\tENDS AT\tSUMMARY")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.CalendarID, e.Event.ID,
+			formatTime(e.Event.StartsAt), formatTime(e.Event.EndsAt), e.Event.Summary)
+	}
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
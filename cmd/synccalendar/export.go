@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal/secret"
+)
+
+var ExportCommand = _exportCommand{
+	Name:        "export",
+	Description: "Export accounts, calendars, links and event mappings to a JSON bundle",
+}
+
+type _exportCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _exportCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	var (
+		output      string
+		withAuth    bool
+		keyFilename string
+	)
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&output, "o", "-", "file the bundle is written to, - for the standard output")
+	fs.BoolVar(&withAuth, "auth", false, "export the auth of the accounts encrypted")
+	fs.StringVar(&keyFilename, "key-file", "", "file with the key the auth is encrypted with, created if it doesn't exist (default the key of the database)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	var key *secret.Key
+	if withAuth {
+		key, err = bundleKey(dbFilename, keyFilename, true)
+		if err != nil {
+			return err
+		}
+	}
+	bundle, err := storage.Export(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bundle); err != nil {
+		return err
+	}
+	if output != "-" {
+		fmt.Fprintf(flag.CommandLine.Output(), "%d account(s), %d calendar(s), %d link(s) and %d event(s) exported to %s\n",
+			len(bundle.Accounts), len(bundle.Calendars), len(bundle.Links), len(bundle.Events), output)
+	}
+	return nil
+}
+
+// bundleKey returns the key the auth of the bundle is encrypted with,
+// when no file is given it's the key of the database.
+func bundleKey(dbFilename, keyFilename string, create bool) (*secret.Key, error) {
+	if keyFilename == "" {
+		return loadKey(dbFilename, false)
+	}
+	key, err := secret.LoadKeyFile(keyFilename, create)
+	if err != nil {
+		return nil, fmt.Errorf("loading key from %s: %w", keyFilename, err)
+	}
+	return key, nil
+}
@@ -1,6 +1,24 @@
 package main
 
-import "strings"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/secret"
+	"github.com/guilherme-santos/synccalendar/internal/sqlite"
+	"github.com/guilherme-santos/synccalendar/internal/syncer"
+)
 
 type Strings []string
 
@@ -12,3 +30,185 @@ func (i *Strings) Set(value string) error {
 	*i = append(*i, value)
 	return nil
 }
+
+// linkOptionsVar defines the flags to configure the options of a link.
+func linkOptionsVar(fs *flag.FlagSet, opts *internal.LinkOptions) {
+	fs.BoolVar(&opts.IgnoreDeclinedEvents, "ignore-declined-events", false, "ignore events that were declined")
+	fs.BoolVar(&opts.IgnoreMyEventsAlone, "ignore-my-events-alone", false, "ignore events that I'm alone")
+	fs.BoolVar(&opts.IgnoreOutOfOfficeEvent, "ignore-out-of-office-alone", false, "ignore out of office events")
+	fs.BoolVar(&opts.IgnoreFocusTimeEvent, "ignore-focus-time-alone", false, "ignore focus time events")
+	fs.Var(&opts.Mirrors, "mirrors", "what to do with events created by us in the source calendar: skip or passthrough (default skip)")
+	fs.DurationVar(&opts.BufferBefore, "buffer-before", 0, "create a busy event of this duration before each mirrored event, e.g. 30m")
+	fs.DurationVar(&opts.BufferAfter, "buffer-after", 0, "create a busy event of this duration after each mirrored event, e.g. 30m")
+	fs.Var(&opts.RSVP.Markers, "rsvp-markers", "markers added to the summary of the mirror for each response, e.g. tentative=(?),needsAction=(!)")
+	fs.Var(&opts.RSVP.Colors, "rsvp-colors", "color ids used by the mirror for each response, e.g. declined=8,tentative=5")
+	fs.BoolVar(&opts.RSVP.ShowAsFree, "rsvp-free", false, "show the mirror of events declined or not answered yet as free")
+}
+
+const (
+	keyEnv     = "SYNCCALENDAR_KEY"
+	keyFileEnv = "SYNCCALENDAR_KEY_FILE"
+)
+
+// keyFile is the file given with the -key-file flag.
+var keyFile string
+
+// keyFilename returns the file with the key used to encrypt the auth of
+// the accounts, by default it's next to the database.
+func keyFilename(dbFilename string) string {
+	if keyFile != "" {
+		return keyFile
+	}
+	if filename := os.Getenv(keyFileEnv); filename != "" {
+		return filename
+	}
+	return strings.TrimSuffix(dbFilename, filepath.Ext(dbFilename)) + ".key"
+}
+
+// loadKey returns the key from the environment or from the key file. The
+// key file is only created when create is set, telling where it's kept.
+func loadKey(dbFilename string, create bool) (*secret.Key, error) {
+	if encoded := os.Getenv(keyEnv); encoded != "" {
+		key, err := secret.ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyEnv, err)
+		}
+		return key, nil
+	}
+	filename := keyFilename(dbFilename)
+	key, err := secret.LoadKeyFile(filename, false)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return nil, fmt.Errorf("%w: key file %s doesn't exist, set %s, use -key-file or run configure or migrate to create it", errNoKey, filename, keyEnv)
+		}
+		key, err = secret.CreateKeyFile(filename)
+		if err == nil {
+			log.Printf("Warning: a new key was saved in %s, keep it apart from the backups of the database, both are needed to use the accounts", filename)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading key from %s: %w", filename, err)
+	}
+	return key, nil
+}
+
+var errNoKey = errors.New("no key to encrypt the accounts")
+
+// openStorage opens the database, migrating it to the latest version. The
+// key must exist already, unless no account was encrypted yet.
+func openStorage(ctx context.Context, dbFilename string) (*sqlite.Storage, error) {
+	key, err := loadKey(dbFilename, false)
+	if errors.Is(err, errNoKey) {
+		key, err = firstKey(ctx, dbFilename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openStorageWithKey(ctx, dbFilename, key)
+}
+
+// firstKey creates the key of a database whose accounts are all saved in
+// plain text, e.g. from before the auth was encrypted. They are encrypted
+// right after opening it with the key.
+func firstKey(ctx context.Context, dbFilename string) (*secret.Key, error) {
+	db, err := sql.Open(sqlite.DriverName, dbFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	storage := sqlite.NewStorage(db, nil)
+	if err := storage.Migrate(ctx, flag.CommandLine.Output()); err != nil {
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+	n, err := storage.EncryptedAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, fmt.Errorf("%w: key file %s doesn't exist but %d account(s) were encrypted, set %s or use -key-file with the key used to encrypt them",
+			errNoKey, keyFilename(dbFilename), n, keyEnv)
+	}
+	return loadKey(dbFilename, true)
+}
+
+// setupStorage opens the database like openStorage, creating the key file
+// if there's no key yet. Only the commands setting up the database use it.
+func setupStorage(ctx context.Context, dbFilename string) (*sqlite.Storage, error) {
+	key, err := loadKey(dbFilename, true)
+	if err != nil {
+		return nil, err
+	}
+	return openStorageWithKey(ctx, dbFilename, key)
+}
+
+func openStorageWithKey(ctx context.Context, dbFilename string, key *secret.Key) (*sqlite.Storage, error) {
+	db, err := sql.Open(sqlite.DriverName, dbFilename)
+	if err != nil {
+		return nil, err
+	}
+	storage := sqlite.NewStorage(db, secret.NewKeyring(key))
+	if err := storage.Migrate(ctx, flag.CommandLine.Output()); err != nil {
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+	if _, err := storage.EncryptAccounts(ctx); err != nil {
+		return nil, fmt.Errorf("encrypting accounts: %w", err)
+	}
+	return storage, nil
+}
+
+// startRun saves the start of a run in the history, the operations done
+// by s are saved with it so they can be undone.
+func startRun(ctx context.Context, storage *sqlite.Storage, s *syncer.Syncer) (*internal.Run, error) {
+	run := &internal.Run{
+		StartedAt: time.Now(),
+		Args:      os.Args[1:],
+	}
+	if err := storage.StartRun(ctx, run); err != nil {
+		return nil, fmt.Errorf("saving run: %v", err)
+	}
+	s.RunID = run.ID
+	return run, nil
+}
+
+// finishRun saves how the run ended, report is nil when no link was
+// synced.
+func finishRun(ctx context.Context, storage *sqlite.Storage, run *internal.Run, report *syncer.SyncReport, err error) {
+	run.EndedAt = time.Now()
+	if report != nil {
+		run.Links = report.RunLinks()
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	// The context might be cancelled already, the run is saved anyway.
+	if err := storage.FinishRun(context.WithoutCancel(ctx), run); err != nil {
+		log.Printf("Unable to save run %d: %v", run.ID, err)
+	}
+}
+
+// subcommandFlagSet returns the flags of cmd, a command of the command
+// called name. usage describes its arguments.
+func subcommandFlagSet(name, cmd, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name+" "+cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s %s:\n", os.Args[0], fs.Name(), usage)
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags and checks that n arguments were given.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args(), nil
+}
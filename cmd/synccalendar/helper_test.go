@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/secret"
+	"github.com/guilherme-santos/synccalendar/internal/sqlite"
+)
+
+func TestOpenStorageWithoutKey(t *testing.T) {
+	tests := []struct {
+		name string
+		// key encrypts the account before opening the database, its key
+		// file is removed then.
+		key     bool
+		wantErr bool
+	}{
+		{"plain text accounts", false, false},
+		{"encrypted accounts", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			t.Setenv(keyEnv, "")
+			t.Setenv(keyFileEnv, "")
+			dbFilename := filepath.Join(t.TempDir(), "synccalendar.db")
+			keyFilename := keyFilename(dbFilename)
+
+			var keys *secret.Keyring
+			if tt.key {
+				key, err := secret.CreateKeyFile(keyFilename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				keys = secret.NewKeyring(key)
+			}
+			db, err := sql.Open(sqlite.DriverName, dbFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage := sqlite.NewStorage(db, keys)
+			if err := storage.Migrate(ctx, io.Discard); err != nil {
+				t.Fatal(err)
+			}
+			acc := &internal.Account{Platform: "google", Name: "alice", Auth: "auth"}
+			if err := storage.AddAccount(ctx, acc); err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+			os.Remove(keyFilename)
+
+			storage, err = openStorage(ctx, dbFilename)
+			if tt.wantErr {
+				if !errors.Is(err, errNoKey) {
+					t.Fatalf("openStorage() = %v, want %v", err, errNoKey)
+				}
+				if _, err := os.Stat(keyFilename); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("key file was created, want it missing")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openStorage() = %v", err)
+			}
+			if _, err := os.Stat(keyFilename); err != nil {
+				t.Errorf("key file wasn't created: %v", err)
+			}
+			if n, err := storage.EncryptedAccounts(ctx); err != nil || n != 1 {
+				t.Errorf("EncryptedAccounts() = %d, %v, want 1", n, err)
+			}
+			got, err := storage.Account(ctx, acc.ID())
+			if err != nil || got.Auth != acc.Auth {
+				t.Errorf("Account() = %+v, %v, want auth %q", got, err, acc.Auth)
+			}
+		})
+	}
+}
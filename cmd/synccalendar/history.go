@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+var HistoryCommand = _historyCommand{
+	Name:        "history",
+	Description: "List the last sync runs or show one of them with \"history <run id>\"",
+}
+
+type _historyCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _historyCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	var limit int
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s [run id]:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.IntVar(&limit, "n", 20, "number of runs to list")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid run id: %q", fs.Arg(0))
+		}
+		run, err := storage.Run(ctx, id)
+		if err != nil {
+			return err
+		}
+		if run == nil {
+			return fmt.Errorf("run %d not found", id)
+		}
+		return printRun(run)
+	}
+
+	runs, err := storage.Runs(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED AT\tDURATION\tLINKS\tCREATED\tUPDATED\tDELETED\tFAILED\tSTATUS")
+	for _, run := range runs {
+		var created, updated, deleted, failed int
+		for _, l := range run.Links {
+			created += l.Created
+			updated += l.Updated
+			deleted += l.Deleted
+			failed += l.Failed
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			run.ID, run.StartedAt.Local().Format(time.DateTime), runDuration(run), len(run.Links), created, updated, deleted, failed, runStatus(run))
+	}
+	return w.Flush()
+}
+
+func printRun(run *internal.Run) error {
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Run:\t%d\n", run.ID)
+	fmt.Fprintf(w, "Started at:\t%s\n", run.StartedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(w, "Duration:\t%s\n", runDuration(run))
+	fmt.Fprintf(w, "Arguments:\t%s\n", strings.Join(run.Args, " "))
+	fmt.Fprintf(w, "Status:\t%s\n", runStatus(run))
+	if run.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", run.Error)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "LINK\tSOURCE\tDESTINATION\tCREATED\tUPDATED\tDELETED\tSKIPPED\tFAILED\tVETOED\tTOKEN ADVANCED\tDURATION")
+	for _, l := range run.Links {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%t\t%s\n",
+			l.LinkID, l.Source, l.Destination, l.Created, l.Updated, l.Deleted, l.Skipped, l.Failed, l.Vetoed, l.TokenAdvanced, l.Duration.Round(time.Millisecond))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, l := range run.Links {
+		for _, err := range l.Errors {
+			fmt.Fprintf(flag.CommandLine.Output(), "Link %d: %s\n", l.LinkID, err)
+		}
+	}
+	return nil
+}
+
+func runDuration(run *internal.Run) string {
+	if run.EndedAt.IsZero() {
+		return "-"
+	}
+	return run.EndedAt.Sub(run.StartedAt).Round(time.Millisecond).String()
+}
+
+func runStatus(run *internal.Run) string {
+	switch {
+	case run.EndedAt.IsZero():
+		return "running"
+	case run.Failed():
+		return "failed"
+	}
+	return "ok"
+}
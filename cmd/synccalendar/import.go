@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/secret"
+)
+
+var ImportCommand = _importCommand{
+	Name:        "import",
+	Description: "Import a bundle created by the export command",
+}
+
+type _importCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _importCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	var (
+		mode        = internal.ImportMerge
+		keyFilename string
+	)
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s <bundle file>:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&mode, "mode", "merge to add the bundle to what is saved or replace to remove everything that isn't in the bundle (default merge)")
+	fs.StringVar(&keyFilename, "key-file", "", "file with the key the auth of the bundle is encrypted with (default the key of the database)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if filename := fs.Arg(0); filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var bundle internal.Bundle
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bundle); err != nil {
+		return fmt.Errorf("%w: %v", internal.ErrInvalidBundle, err)
+	}
+	if err := bundle.Validate(); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	var key *secret.Key
+	if bundle.KeyID != "" {
+		key, err = bundleKey(dbFilename, keyFilename, false)
+		if err != nil {
+			return err
+		}
+	}
+	if err := storage.Import(ctx, &bundle, mode, key); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(flag.CommandLine.Output(), "%d account(s), %d calendar(s), %d link(s) and %d event(s) imported\n",
+		len(bundle.Accounts), len(bundle.Calendars), len(bundle.Links), len(bundle.Events))
+	if bundle.KeyID == "" {
+		fmt.Fprintln(flag.CommandLine.Output(), "The bundle has no auth, new accounts must log in again with the configure command")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -11,7 +12,7 @@ import (
 
 func main() {
 	log.SetFlags(0) // log.LstdFlags | log.Lshortfile | log.Ltime)
-	log.SetOutput(os.Stdout)
+	log.SetOutput(os.Stderr)
 
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
@@ -25,10 +26,20 @@ func main() {
 	}()
 
 	if err := runMain(ctx); err != nil {
+		if errors.Is(err, errPartialFailure) {
+			log.Print("Error: ", err)
+			os.Exit(exitPartialFailure)
+		}
 		log.Fatal("Error: ", err)
 	}
 }
 
+// exitPartialFailure is the exit code used when the sync finished but
+// some events couldn't be synced.
+const exitPartialFailure = 3
+
+var errPartialFailure = errors.New("some events couldn't be synced")
+
 func runMain(ctx context.Context) (err error) {
 	var (
 		verbose    bool
@@ -38,6 +49,7 @@ func runMain(ctx context.Context) (err error) {
 	flag.BoolVar(&verbose, "v", false, "verbose mode")
 	flag.BoolVar(&verbose, "verbose", false, "verbose mode")
 	flag.StringVar(&dbFilename, "db", "./synccalendar.db", "database file")
+	flag.StringVar(&keyFile, "key-file", "", "file with the key encrypting the accounts (default next to the database)")
 	flag.Usage = func() {
 		w := flag.CommandLine.Output()
 		fmt.Fprintf(w, "%s syncs two or more calendars with another calendar\n", os.Args[0])
@@ -48,6 +60,18 @@ func runMain(ctx context.Context) (err error) {
 		fmt.Fprintln(w, "Commands:")
 		fmt.Fprintf(w, "  %-4s    %s\n", SyncCommand.Name, SyncCommand.Description)
 		fmt.Fprintf(w, "  %-4s    %s\n", ConfigureCommand.Name, ConfigureCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", CalendarCommand.Name, CalendarCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", AccountsCommand.Name, AccountsCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", RepairCommand.Name, RepairCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", RetriesCommand.Name, RetriesCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", StatusCommand.Name, StatusCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", HistoryCommand.Name, HistoryCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", UndoCommand.Name, UndoCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", EventsCommand.Name, EventsCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", MigrateCommand.Name, MigrateCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", ExportCommand.Name, ExportCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", ImportCommand.Name, ImportCommand.Description)
+		fmt.Fprintf(w, "  %-4s    %s\n", RotateKeyCommand.Name, RotateKeyCommand.Description)
 		fmt.Fprintln(w)
 		fmt.Fprintf(w, "Use \"%s <command> --help\" for more information about a given command.", os.Args[0])
 		fmt.Fprintln(w)
@@ -65,8 +89,41 @@ func runMain(ctx context.Context) (err error) {
 	case ConfigureCommand.Name:
 		err = ConfigureCommand.Run(ctx, dbFilename, verbose, flag.Args()[1:])
 
+	case RepairCommand.Name:
+		err = RepairCommand.Run(ctx, dbFilename, verbose, flag.Args()[1:])
+
+	case RetriesCommand.Name:
+		err = RetriesCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
+	case StatusCommand.Name:
+		err = StatusCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
+	case HistoryCommand.Name:
+		err = HistoryCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
+	case UndoCommand.Name:
+		err = UndoCommand.Run(ctx, dbFilename, verbose, flag.Args()[1:])
+
+	case EventsCommand.Name:
+		err = EventsCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
+	case MigrateCommand.Name:
+		err = MigrateCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
+	case ExportCommand.Name:
+		err = ExportCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
+	case ImportCommand.Name:
+		err = ImportCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
+	case RotateKeyCommand.Name:
+		err = RotateKeyCommand.Run(ctx, dbFilename, flag.Args()[1:])
+
 	case CalendarCommand.Name:
-		err = CalendarCommand.Run(ctx, flag.Args()[1:])
+		err = CalendarCommand.Run(ctx, dbFilename, verbose, flag.Args()[1:])
+
+	case AccountsCommand.Name:
+		err = AccountsCommand.Run(ctx, dbFilename, verbose, flag.Args()[1:])
 
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command %q", flag.Arg(0))
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal/sqlite"
+)
+
+var MigrateCommand = _migrateCommand{
+	Name:        "migrate",
+	Description: "Migrate the database or show its version with \"migrate status\"",
+}
+
+type _migrateCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _migrateCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s [status]:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "":
+		storage, err := setupStorage(ctx, dbFilename)
+		if err != nil {
+			return err
+		}
+		current, _, err := storage.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "Database is at version %d\n", current)
+		return nil
+
+	case "status":
+		db, err := sql.Open(sqlite.DriverName, dbFilename)
+		if err != nil {
+			return err
+		}
+		return s.status(ctx, sqlite.NewStorage(db, nil))
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func (s _migrateCommand) status(ctx context.Context, storage *sqlite.Storage) error {
+	migrations, err := storage.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+	for _, m := range migrations {
+		appliedAt := "pending"
+		if m.Applied() {
+			appliedAt = m.AppliedAt.Local().Format(time.DateTime)
+		}
+		if m.Unknown {
+			appliedAt += " (unknown to this binary)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Description, appliedAt)
+	}
+	return w.Flush()
+}
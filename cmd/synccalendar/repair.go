@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal/syncer"
+)
+
+var RepairCommand = _repairCommand{
+	Name:        "repair",
+	Description: "Fix differences between the destination calendars and what was synced",
+}
+
+type _repairCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _repairCommand) Run(ctx context.Context, dbFilename string, verbose bool, args []string) error {
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+	mux, err := newMux(verbose, storage)
+	if err != nil {
+		return err
+	}
+
+	syncer := syncer.New(flag.CommandLine.Output(), mux, storage)
+
+	var calIDs Strings
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&calIDs, "calendar-id", "calendar-id to be repaired")
+	fs.BoolVar(&syncer.IgnoreDeclinedEvents, "ignore-declined-events", false, "ignore events that were declined")
+	fs.BoolVar(&syncer.IgnoreMyEventsAlone, "ignore-my-events-alone", false, "ignore events that I'm alone")
+	fs.BoolVar(&syncer.IgnoreOutOfOfficeEvent, "ignore-out-of-office-alone", false, "ignore out of office events")
+	fs.BoolVar(&syncer.IgnoreFocusTimeEvent, "ignore-focus-time-alone", false, "ignore focus time events")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	run, err := startRun(ctx, storage, syncer)
+	if err != nil {
+		return err
+	}
+	reports, err := syncer.Reconcile(ctx, calIDs)
+	finishRun(ctx, storage, run, nil, err)
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CALENDAR\tRECREATED\tDELETED\tORPHANS\tDROPPED MAPPINGS\tFAILED")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", r.Calendar, r.Recreated, r.Deleted, r.Orphans, r.DroppedMappings, r.Failed)
+	}
+	w.Flush()
+	if err == nil {
+		for _, r := range reports {
+			if r.Failed > 0 {
+				return errPartialFailure
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+var RetriesCommand = _retriesCommand{
+	Name:        "retries",
+	Description: "List events that failed to sync and will be tried again",
+}
+
+type _retriesCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _retriesCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	var dead bool
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&dead, "dead", false, "only list events that won't be tried again")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var status internal.RetryStatus
+	if dead {
+		status = internal.RetryDead
+	}
+	retries, err := storage.Retries(ctx, status)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CALENDAR\tSOURCE\tEVENT\tOPERATION\tATTEMPTS\tNEXT ATTEMPT\tSTATUS\tLAST ERROR")
+	for _, r := range retries {
+		nextAttempt := r.NextAttemptAt.Local().Format(time.DateTime)
+		if r.Status == internal.RetryDead {
+			nextAttempt = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			r.CalendarID, r.SrcCalendarID, r.Event.ID, r.Operation, r.Attempts, nextAttempt, r.Status, r.LastError)
+	}
+	return w.Flush()
+}
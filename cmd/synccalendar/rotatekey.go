@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/guilherme-santos/synccalendar/internal/secret"
+)
+
+var RotateKeyCommand = _rotateKeyCommand{
+	Name:        "rotate-key",
+	Description: "Encrypt the auth of all accounts with a new key",
+}
+
+type _rotateKeyCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _rotateKeyCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	var newKeyFilename string
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&newKeyFilename, "new-key-file", "", "file with the new key, created if it doesn't exist (default replaces the current key file)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	keyFilename := keyFilename(dbFilename)
+	replace := newKeyFilename == ""
+	if replace {
+		if os.Getenv(keyEnv) != "" {
+			return fmt.Errorf("the key is read from %s, use -new-key-file", keyEnv)
+		}
+		// A file left by a rotation that didn't finish is used again, some
+		// accounts may be encrypted with it already.
+		newKeyFilename = keyFilename + ".new"
+	}
+
+	key, err := secret.LoadKeyFile(newKeyFilename, true)
+	if err != nil {
+		return fmt.Errorf("loading new key: %w", err)
+	}
+	n, err := storage.RotateKey(ctx, key)
+	if err != nil {
+		return fmt.Errorf("rotating key: %w", err)
+	}
+
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "%d account(s) encrypted with key %s\n", n, key.ID())
+	if !replace {
+		fmt.Fprintf(w, "Use %s as key from now on\n", newKeyFilename)
+		return nil
+	}
+	if err := os.Rename(newKeyFilename, keyFilename); err != nil {
+		return fmt.Errorf("replacing key file, use %s as key from now on: %w", newKeyFilename, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var StatusCommand = _statusCommand{
+	Name:        "status",
+	Description: "Show the auth health of the accounts and the status of the links",
+}
+
+type _statusCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _statusCommand) Run(ctx context.Context, dbFilename string, args []string) error {
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	accounts, err := storage.Accounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tAUTH\tERROR")
+	for _, acc := range accounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", acc.ID(), acc.AuthStatus, acc.AuthError)
+	}
+	fmt.Fprintln(w)
+
+	dstcals, err := storage.DestinationCalendars(ctx, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "LINK\tSOURCE\tDESTINATION\tSTATUS")
+	for _, dst := range dstcals {
+		links, err := storage.Links(ctx, dst)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			status := link.Status.String()
+			if link.Source.Account.NeedsReauth() || dst.Account.NeedsReauth() {
+				status = "needs reauth"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", link.ID, link.Source, dst, status)
+		}
+	}
+	return w.Flush()
+}
@@ -2,17 +2,19 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"text/tabwriter"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/guilherme-santos/synccalendar/calendar"
 	"github.com/guilherme-santos/synccalendar/calendar/google"
 	"github.com/guilherme-santos/synccalendar/internal"
-	"github.com/guilherme-santos/synccalendar/internal/sqlite"
 	"github.com/guilherme-santos/synccalendar/internal/syncer"
 )
 
@@ -27,13 +29,11 @@ type _syncCommand struct {
 }
 
 func (s _syncCommand) Run(ctx context.Context, dbFilename string, verbose bool, args []string) error {
-	db, err := sql.Open(sqlite.DriverName, dbFilename)
+	storage, err := openStorage(ctx, dbFilename)
 	if err != nil {
 		return err
 	}
-
-	storage := sqlite.NewStorage(db)
-	mux, err := newMux(verbose)
+	mux, err := newMux(verbose, storage)
 	if err != nil {
 		return err
 	}
@@ -44,6 +44,8 @@ func (s _syncCommand) Run(ctx context.Context, dbFilename string, verbose bool,
 		force     bool
 		forceFrom internal.Date
 		calIDs    Strings
+		reportFmt string
+		retention int
 	)
 
 	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
@@ -54,26 +56,73 @@ func (s _syncCommand) Run(ctx context.Context, dbFilename string, verbose bool,
 		fmt.Fprintf(w, "Options:\n")
 		fs.PrintDefaults()
 	}
-	fs.BoolVar(&force, "force", false, "delete all events and insert then again")
+	fs.BoolVar(&force, "force", false, "delete all events created by us and insert then again")
 	fs.Var(&forceFrom, "force-from", "force events since the date (e.g. 2022-08-12)")
 	fs.Var(&calIDs, "calendar-id", "calendar-id to be synced")
 	fs.BoolVar(&syncer.IgnoreDeclinedEvents, "ignore-declined-events", false, "ignore events that were declined")
 	fs.BoolVar(&syncer.IgnoreMyEventsAlone, "ignore-my-events-alone", false, "ignore events that I'm alone")
 	fs.BoolVar(&syncer.IgnoreOutOfOfficeEvent, "ignore-out-of-office-alone", false, "ignore out of office events")
 	fs.BoolVar(&syncer.IgnoreFocusTimeEvent, "ignore-focus-time-alone", false, "ignore focus time events")
+	fs.StringVar(&reportFmt, "report", "table", "format of the report printed at the end: table or json")
+	fs.IntVar(&syncer.MaxAttempts, "max-attempts", syncer.MaxAttempts, "how many times an event that failed to sync is tried")
+	fs.IntVar(&retention, "history-retention", 90, "days to keep the history of the runs, 0 keeps it forever")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
-	return syncer.Sync(ctx, calIDs, force, forceFrom)
+	if reportFmt != "table" && reportFmt != "json" {
+		return fmt.Errorf("invalid report format: %q", reportFmt)
+	}
+
+	run, err := startRun(ctx, storage, syncer)
+	if err != nil {
+		return err
+	}
+	report, err := syncer.Sync(ctx, calIDs, force, forceFrom)
+	report.RunID = run.ID
+	finishRun(ctx, storage, run, report, err)
+	if retention > 0 {
+		_, err := storage.DeleteRunsBefore(ctx, run.StartedAt.AddDate(0, 0, -retention))
+		if err != nil {
+			log.Printf("Unable to delete old runs: %v", err)
+		}
+	}
+	if reportFmt == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printReport(report)
+	}
+	if err == nil && report.Failed() {
+		err = errPartialFailure
+	}
+	return err
+}
+
+func printReport(report *syncer.SyncReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tSOURCE\tDESTINATION\tCREATED\tUPDATED\tDELETED\tSKIPPED\tFAILED\tVETOED\tTOKEN ADVANCED\tDURATION")
+	for _, l := range report.Links {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%t\t%s\n",
+			l.LinkID, l.Source, l.Destination, l.Created, l.Updated, l.Deleted, l.Skipped, l.Failed, l.Vetoed, l.TokenAdvanced, l.Duration.Round(time.Millisecond))
+	}
+	w.Flush()
+
+	for _, l := range report.Links {
+		for _, err := range l.Errors {
+			fmt.Fprintf(os.Stdout, "Link %d: %s\n", l.LinkID, err)
+		}
+	}
 }
 
-func newMux(verbose bool) (internal.Mux, error) {
+func newMux(verbose bool, accounts internal.AccountStorage) (internal.Mux, error) {
 	googleCal, err := google.NewClient(nil)
 	if err != nil {
 		return nil, err
 	}
 	googleCal.Verbose = verbose
+	googleCal.Accounts = accounts
 
 	mux := calendar.NewMux()
 	mux.Register(googleProvider, googleCal)
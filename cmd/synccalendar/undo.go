@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/guilherme-santos/synccalendar/internal/syncer"
+)
+
+var UndoCommand = _undoCommand{
+	Name:        "undo",
+	Description: "Restore the destination calendars to their state before a sync run",
+}
+
+type _undoCommand struct {
+	Name        string
+	Description string
+}
+
+func (s _undoCommand) Run(ctx context.Context, dbFilename string, verbose bool, args []string) error {
+	storage, err := openStorage(ctx, dbFilename)
+	if err != nil {
+		return err
+	}
+	mux, err := newMux(verbose, storage)
+	if err != nil {
+		return err
+	}
+
+	syncer := syncer.New(flag.CommandLine.Output(), mux, storage)
+
+	var (
+		runID  int64
+		dryRun bool
+	)
+
+	fs := flag.NewFlagSet(s.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Int64Var(&runID, "run", 0, "id of the run to be undone, see the history command")
+	fs.BoolVar(&dryRun, "dry-run", false, "only show what would be undone")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if runID == 0 {
+		return errors.New("-run is required")
+	}
+	run, err := storage.Run(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return fmt.Errorf("run %d not found", runID)
+	}
+
+	report, err := syncer.Undo(ctx, runID, dryRun)
+	if report == nil || dryRun {
+		return err
+	}
+
+	w := tabwriter.NewWriter(flag.CommandLine.Output(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tDELETED\tRESTORED\tREVERTED\tSKIPPED\tFAILED")
+	fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\n", report.RunID, report.Deleted, report.Restored, report.Reverted, report.Skipped, report.Failed)
+	w.Flush()
+	if err == nil && report.Failed > 0 {
+		err = errors.New("some events couldn't be restored, check the logs")
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package internal
+
+import "time"
+
+type AuditKind string
+
+func (k AuditKind) String() string {
+	return string(k)
+}
+
+var (
+	// AuditEvent is a mirror, buffer or any other event written by us
+	// through the events mapping.
+	AuditEvent AuditKind = "event"
+	// AuditBusy is a busy block.
+	AuditBusy AuditKind = "busy"
+)
+
+// AuditEntry records an operation done by the syncer on a destination
+// calendar. Before is the event before the operation and After the event
+// written, Before is nil on creates and After on deletes.
+type AuditEntry struct {
+	ID         int64
+	RunID      int64
+	LinkID     int64
+	CalendarID string
+	EventID    string
+	Kind       AuditKind
+	Operation  Operation
+	// Where the event was mirrored from, empty for events that aren't
+	// mapped, like busy blocks or orphans.
+	SrcCalendarID string
+	SrcEventID    string
+	ParentID      string
+	Before        *Event
+	After         *Event
+	CreatedAt     time.Time
+	// UndoneAt is set once the operation is undone.
+	UndoneAt time.Time
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BundleVersion is the version of the bundles exported, bundles of newer
+// versions can't be imported. Version 2 added the sources of dedup and
+// busy calendars and the busy blocks.
+const BundleVersion = 2
+
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// Bundle holds the accounts, calendars, links and event mappings, it's
+// used to move them to another database.
+type Bundle struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	// KeyID is the id of the key the auth of the accounts is encrypted
+	// with, it's empty when the bundle has no auth.
+	KeyID     string            `json:"key_id,omitempty"`
+	Accounts  []*BundleAccount  `json:"accounts"`
+	Calendars []*BundleCalendar `json:"calendars"`
+	Links     []*BundleLink     `json:"links"`
+	Events    []*BundleEvent    `json:"events"`
+	// EventSources are the source events merged in the events of dedup
+	// calendars.
+	EventSources []*BundleEventSource `json:"event_sources,omitempty"`
+	// BusySources are the source events keeping busy calendars busy.
+	BusySources []*BundleBusySource `json:"busy_sources,omitempty"`
+	BusyBlocks  []*BundleBusyBlock  `json:"busy_blocks,omitempty"`
+}
+
+type BundleAccount struct {
+	ID   string `json:"id"`
+	Auth string `json:"auth,omitempty"`
+}
+
+type BundleCalendar struct {
+	AccountID  string       `json:"account_id"`
+	Name       string       `json:"name"`
+	ProviderID string       `json:"provider_id"`
+	Mode       CalendarMode `json:"mode,omitempty"`
+}
+
+func (c BundleCalendar) ID() string {
+	return c.AccountID + "/" + c.Name
+}
+
+type BundleLink struct {
+	Source      string      `json:"source"`
+	Destination string      `json:"destination"`
+	LastSync    string      `json:"last_sync,omitempty"`
+	Options     LinkOptions `json:"options"`
+	Status      LinkStatus  `json:"status"`
+}
+
+type BundleEvent struct {
+	CalendarID    string    `json:"calendar_id"`
+	EventID       string    `json:"event_id"`
+	SrcCalendarID string    `json:"src_calendar_id"`
+	SrcEventID    string    `json:"src_event_id"`
+	ParentID      string    `json:"parent_id,omitempty"`
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+}
+
+type BundleEventSource struct {
+	CalendarID    string `json:"calendar_id"`
+	EventID       string `json:"event_id"`
+	SrcCalendarID string `json:"src_calendar_id"`
+	SrcEventID    string `json:"src_event_id"`
+	DedupKey      string `json:"dedup_key"`
+	Active        bool   `json:"active"`
+}
+
+type BundleBusySource struct {
+	CalendarID    string    `json:"calendar_id"`
+	SrcCalendarID string    `json:"src_calendar_id"`
+	SrcEventID    string    `json:"src_event_id"`
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+}
+
+type BundleBusyBlock struct {
+	CalendarID string    `json:"calendar_id"`
+	EventID    string    `json:"event_id"`
+	StartsAt   time.Time `json:"starts_at"`
+	EndsAt     time.Time `json:"ends_at"`
+}
+
+type ImportMode string
+
+func (m ImportMode) String() string {
+	return string(m)
+}
+
+func (m *ImportMode) Set(value string) error {
+	switch v := ImportMode(value); v {
+	case ImportMerge, ImportReplace:
+		*m = v
+		return nil
+	}
+	return fmt.Errorf("invalid import mode %q, use merge or replace", value)
+}
+
+var (
+	// ImportMerge adds the bundle to what is already saved, what is in
+	// both is replaced by the bundle.
+	ImportMerge ImportMode = "merge"
+	// ImportReplace removes everything saved before importing the bundle.
+	ImportReplace ImportMode = "replace"
+)
+
+// Validate checks that the bundle can be imported, everything must be
+// unique and reference something in the bundle.
+func (b *Bundle) Validate() error {
+	if b.Version < 1 || b.Version > BundleVersion {
+		return fmt.Errorf("%w: version %d is not supported, the latest is %d", ErrInvalidBundle, b.Version, BundleVersion)
+	}
+
+	accounts := make(map[string]bool, len(b.Accounts))
+	for _, a := range b.Accounts {
+		platform, name, ok := strings.Cut(a.ID, "/")
+		if !ok || platform == "" || name == "" {
+			return fmt.Errorf("%w: account %q must be platform/name", ErrInvalidBundle, a.ID)
+		}
+		if accounts[a.ID] {
+			return fmt.Errorf("%w: account %s is duplicated", ErrInvalidBundle, a.ID)
+		}
+		if a.Auth != "" && b.KeyID == "" {
+			return fmt.Errorf("%w: auth of account %s is given without key id", ErrInvalidBundle, a.ID)
+		}
+		accounts[a.ID] = true
+	}
+
+	calendars := make(map[string]bool, len(b.Calendars))
+	for _, c := range b.Calendars {
+		if !accounts[c.AccountID] {
+			return fmt.Errorf("%w: account %s of calendar %s is missing", ErrInvalidBundle, c.AccountID, c.ID())
+		}
+		if c.Name == "" || c.ProviderID == "" {
+			return fmt.Errorf("%w: calendar %s must have a name and a provider id", ErrInvalidBundle, c.ID())
+		}
+		switch c.Mode {
+		case CalendarModeDefault, CalendarModeDedup, CalendarModeBusy:
+		default:
+			return fmt.Errorf("%w: calendar %s has an invalid mode %q", ErrInvalidBundle, c.ID(), c.Mode)
+		}
+		if calendars[c.ID()] {
+			return fmt.Errorf("%w: calendar %s is duplicated", ErrInvalidBundle, c.ID())
+		}
+		calendars[c.ID()] = true
+	}
+
+	links := make(map[string]bool, len(b.Links))
+	destinations := make(map[string]bool)
+	for _, l := range b.Links {
+		id := l.Source + " -> " + l.Destination
+		if !calendars[l.Source] || !calendars[l.Destination] {
+			return fmt.Errorf("%w: calendars of link %s are missing", ErrInvalidBundle, id)
+		}
+		switch l.Status {
+		case LinkActive, LinkPaused:
+		default:
+			return fmt.Errorf("%w: link %s has an invalid status %q", ErrInvalidBundle, id, l.Status)
+		}
+		if links[id] {
+			return fmt.Errorf("%w: link %s is duplicated", ErrInvalidBundle, id)
+		}
+		links[id] = true
+		destinations[l.Destination] = true
+	}
+
+	events := make(map[string]bool, len(b.Events))
+	for _, e := range b.Events {
+		if !destinations[e.CalendarID] {
+			return fmt.Errorf("%w: event %s is mapped to %s, which isn't a destination", ErrInvalidBundle, e.EventID, e.CalendarID)
+		}
+		if e.EventID == "" || e.SrcEventID == "" {
+			return fmt.Errorf("%w: event mapped to %s must have an id and a source id", ErrInvalidBundle, e.CalendarID)
+		}
+		// Events mapped before their source calendar was saved have none.
+		if e.SrcCalendarID != "" && !calendars[e.SrcCalendarID] {
+			return fmt.Errorf("%w: source calendar %s of event %s is missing", ErrInvalidBundle, e.SrcCalendarID, e.EventID)
+		}
+		id := e.CalendarID + "/" + e.EventID
+		if events[id] {
+			return fmt.Errorf("%w: event %s of %s is duplicated", ErrInvalidBundle, e.EventID, e.CalendarID)
+		}
+		events[id] = true
+	}
+	for _, e := range b.Events {
+		if e.ParentID != "" && !events[e.CalendarID+"/"+e.ParentID] {
+			return fmt.Errorf("%w: parent %s of event %s of %s is missing", ErrInvalidBundle, e.ParentID, e.EventID, e.CalendarID)
+		}
+	}
+
+	sources := make(map[string]bool, len(b.EventSources)+len(b.BusySources))
+	for _, e := range b.EventSources {
+		if !destinations[e.CalendarID] || !calendars[e.SrcCalendarID] {
+			return fmt.Errorf("%w: calendars of source %s of event %s are missing", ErrInvalidBundle, e.SrcEventID, e.EventID)
+		}
+		if e.EventID == "" || e.SrcEventID == "" {
+			return fmt.Errorf("%w: source of event mapped to %s must have an id and a source id", ErrInvalidBundle, e.CalendarID)
+		}
+		id := "event " + e.CalendarID + "/" + e.SrcCalendarID + "/" + e.SrcEventID
+		if sources[id] {
+			return fmt.Errorf("%w: source %s of event %s is duplicated", ErrInvalidBundle, e.SrcEventID, e.EventID)
+		}
+		sources[id] = true
+	}
+	for _, e := range b.BusySources {
+		if !destinations[e.CalendarID] || !calendars[e.SrcCalendarID] {
+			return fmt.Errorf("%w: calendars of busy source %s are missing", ErrInvalidBundle, e.SrcEventID)
+		}
+		if e.SrcEventID == "" {
+			return fmt.Errorf("%w: busy source of %s must have a source id", ErrInvalidBundle, e.CalendarID)
+		}
+		id := "busy " + e.CalendarID + "/" + e.SrcCalendarID + "/" + e.SrcEventID
+		if sources[id] {
+			return fmt.Errorf("%w: busy source %s of %s is duplicated", ErrInvalidBundle, e.SrcEventID, e.CalendarID)
+		}
+		sources[id] = true
+	}
+
+	blocks := make(map[string]bool, len(b.BusyBlocks))
+	for _, e := range b.BusyBlocks {
+		if !destinations[e.CalendarID] {
+			return fmt.Errorf("%w: busy block %s is in %s, which isn't a destination", ErrInvalidBundle, e.EventID, e.CalendarID)
+		}
+		if e.EventID == "" {
+			return fmt.Errorf("%w: busy block of %s must have an id", ErrInvalidBundle, e.CalendarID)
+		}
+		id := e.CalendarID + "/" + e.EventID
+		if blocks[id] {
+			return fmt.Errorf("%w: busy block %s of %s is duplicated", ErrInvalidBundle, e.EventID, e.CalendarID)
+		}
+		blocks[id] = true
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testBundle() *Bundle {
+	return &Bundle{
+		Version: BundleVersion,
+		KeyID:   "key",
+		Accounts: []*BundleAccount{
+			{ID: "google/alice", Auth: "auth"},
+			{ID: "google/bob"},
+		},
+		Calendars: []*BundleCalendar{
+			{AccountID: "google/alice", Name: "work", ProviderID: "alice@work"},
+			{AccountID: "google/bob", Name: "home", ProviderID: "bob@home", Mode: CalendarModeBusy},
+		},
+		Links: []*BundleLink{
+			{Source: "google/alice/work", Destination: "google/bob/home", Status: LinkActive},
+		},
+		Events: []*BundleEvent{
+			{CalendarID: "google/bob/home", EventID: "e1", SrcCalendarID: "google/alice/work", SrcEventID: "s1"},
+		},
+		EventSources: []*BundleEventSource{
+			{CalendarID: "google/bob/home", EventID: "e1", SrcCalendarID: "google/alice/work", SrcEventID: "s1", DedupKey: "key", Active: true},
+		},
+		BusySources: []*BundleBusySource{
+			{CalendarID: "google/bob/home", SrcCalendarID: "google/alice/work", SrcEventID: "s1"},
+		},
+		BusyBlocks: []*BundleBusyBlock{
+			{CalendarID: "google/bob/home", EventID: "b1"},
+		},
+	}
+}
+
+func TestBundleValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(b *Bundle)
+		want   string
+	}{
+		{"valid", func(b *Bundle) {}, ""},
+		{"without auth or key", func(b *Bundle) {
+			b.KeyID, b.Accounts[0].Auth = "", ""
+		}, ""},
+		{"old version", func(b *Bundle) { b.Version = 0 }, "version 0 is not supported"},
+		{"new version", func(b *Bundle) { b.Version = BundleVersion + 1 }, "is not supported"},
+		{"account without name", func(b *Bundle) { b.Accounts[1].ID = "google/" }, "must be platform/name"},
+		{"account without platform", func(b *Bundle) { b.Accounts[1].ID = "bob" }, "must be platform/name"},
+		{"duplicated account", func(b *Bundle) {
+			b.Accounts = append(b.Accounts, &BundleAccount{ID: "google/bob"})
+		}, "account google/bob is duplicated"},
+		{"auth without key", func(b *Bundle) { b.KeyID = "" }, "without key id"},
+		{"calendar without account", func(b *Bundle) {
+			b.Calendars[0].AccountID = "google/carol"
+		}, "account google/carol of calendar google/carol/work is missing"},
+		{"calendar without provider id", func(b *Bundle) { b.Calendars[0].ProviderID = "" }, "must have a name and a provider id"},
+		{"calendar with invalid mode", func(b *Bundle) { b.Calendars[0].Mode = "other" }, `invalid mode "other"`},
+		{"duplicated calendar", func(b *Bundle) {
+			b.Calendars = append(b.Calendars, &BundleCalendar{AccountID: "google/alice", Name: "work", ProviderID: "other"})
+		}, "calendar google/alice/work is duplicated"},
+		{"link without destination", func(b *Bundle) {
+			b.Links[0].Destination = "google/bob/work"
+		}, "calendars of link google/alice/work -> google/bob/work are missing"},
+		{"link with invalid status", func(b *Bundle) { b.Links[0].Status = "" }, `invalid status ""`},
+		{"duplicated link", func(b *Bundle) {
+			b.Links = append(b.Links, &BundleLink{Source: "google/alice/work", Destination: "google/bob/home", Status: LinkPaused})
+		}, "is duplicated"},
+		{"event outside destinations", func(b *Bundle) {
+			b.Events[0].CalendarID = "google/alice/work"
+		}, "isn't a destination"},
+		{"event without source id", func(b *Bundle) { b.Events[0].SrcEventID = "" }, "must have an id and a source id"},
+		{"event without source calendar", func(b *Bundle) { b.Events[0].SrcCalendarID = "" }, ""},
+		{"event with missing source calendar", func(b *Bundle) {
+			b.Events[0].SrcCalendarID = "google/carol/work"
+		}, "source calendar google/carol/work of event e1 is missing"},
+		{"event with parent", func(b *Bundle) {
+			b.Events = append(b.Events, &BundleEvent{CalendarID: "google/bob/home", EventID: "e2", SrcEventID: "s1#buffer-before", ParentID: "e1"})
+		}, ""},
+		{"event with missing parent", func(b *Bundle) {
+			b.Events = append(b.Events, &BundleEvent{CalendarID: "google/bob/home", EventID: "e2", SrcEventID: "s1#buffer-before", ParentID: "e3"})
+		}, "parent e3 of event e2 of google/bob/home is missing"},
+		{"duplicated event", func(b *Bundle) {
+			b.Events = append(b.Events, &BundleEvent{CalendarID: "google/bob/home", EventID: "e1", SrcEventID: "s2"})
+		}, "event e1 of google/bob/home is duplicated"},
+		{"version 1 without sources", func(b *Bundle) {
+			b.Version, b.EventSources, b.BusySources, b.BusyBlocks = 1, nil, nil, nil
+		}, ""},
+		{"event source outside destinations", func(b *Bundle) {
+			b.EventSources[0].CalendarID = "google/alice/work"
+		}, "calendars of source s1 of event e1 are missing"},
+		{"event source with missing source calendar", func(b *Bundle) {
+			b.EventSources[0].SrcCalendarID = "google/carol/work"
+		}, "calendars of source s1 of event e1 are missing"},
+		{"event source without id", func(b *Bundle) { b.EventSources[0].EventID = "" }, "must have an id and a source id"},
+		{"duplicated event source", func(b *Bundle) {
+			b.EventSources = append(b.EventSources, &BundleEventSource{CalendarID: "google/bob/home", EventID: "e2", SrcCalendarID: "google/alice/work", SrcEventID: "s1"})
+		}, "source s1 of event e2 is duplicated"},
+		{"busy source with missing source calendar", func(b *Bundle) {
+			b.BusySources[0].SrcCalendarID = "google/carol/work"
+		}, "calendars of busy source s1 are missing"},
+		{"busy source without source id", func(b *Bundle) { b.BusySources[0].SrcEventID = "" }, "must have a source id"},
+		{"duplicated busy source", func(b *Bundle) {
+			b.BusySources = append(b.BusySources, &BundleBusySource{CalendarID: "google/bob/home", SrcCalendarID: "google/alice/work", SrcEventID: "s1"})
+		}, "busy source s1 of google/bob/home is duplicated"},
+		{"busy block outside destinations", func(b *Bundle) {
+			b.BusyBlocks[0].CalendarID = "google/alice/work"
+		}, "isn't a destination"},
+		{"busy block without id", func(b *Bundle) { b.BusyBlocks[0].EventID = "" }, "must have an id"},
+		{"duplicated busy block", func(b *Bundle) {
+			b.BusyBlocks = append(b.BusyBlocks, &BundleBusyBlock{CalendarID: "google/bob/home", EventID: "b1"})
+		}, "busy block b1 of google/bob/home is duplicated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBundle()
+			tt.change(b)
+			err := b.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidBundle) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Validate() = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
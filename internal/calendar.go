@@ -1,23 +1,202 @@
 package internal
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrLinkCycle is returned when linking calendars that would mirror
+// events back into one of the calendars they came from.
+var ErrLinkCycle = errors.New("link creates a cycle between calendars")
+
 type Account struct {
-	Platform string
-	Name     string
-	Auth     string
+	Platform   string
+	Name       string
+	Auth       string
+	AuthStatus AuthStatus
+	// AuthError is why the account needs to authenticate again.
+	AuthError string
 }
 
 func (a Account) ID() string {
 	return a.Platform + "/" + a.Name
 }
 
+func (a Account) NeedsReauth() bool {
+	return a.AuthStatus == AuthExpired
+}
+
+type AuthStatus string
+
+func (s AuthStatus) String() string {
+	return string(s)
+}
+
+var (
+	AuthOK AuthStatus = "ok"
+	// AuthExpired is set when the auth was revoked or expired.
+	AuthExpired AuthStatus = "expired"
+)
+
 type Calendar struct {
 	ID         string
 	Name       string
 	ProviderID string
 	Account    Account
-	LastSync   string
+	Mode       CalendarMode
 }
 
 func (c Calendar) String() string {
 	return c.ID
 }
+
+// ProviderCalendar is a calendar the account has access to on the
+// provider, used to choose which calendars are linked.
+type ProviderCalendar struct {
+	ID      string
+	Summary string
+	Primary bool
+	// ReadOnly is set when events can't be created in the calendar.
+	ReadOnly bool
+}
+
+// CalendarMode defines how events are written in a destination calendar.
+type CalendarMode string
+
+func (m CalendarMode) String() string {
+	return string(m)
+}
+
+var (
+	// CalendarModeDefault creates one event for each source event.
+	CalendarModeDefault CalendarMode = ""
+	// CalendarModeDedup creates only one event for the same event received
+	// from several sources.
+	CalendarModeDedup CalendarMode = "dedup"
+	// CalendarModeBusy creates opaque busy blocks covering the events of
+	// all sources, overlapping events are merged in the same block.
+	CalendarModeBusy CalendarMode = "busy"
+)
+
+// Link mirrors the events of the source calendar into the destination
+// calendar, each link keeps its own sync token.
+type Link struct {
+	ID          int64
+	Source      *Calendar
+	Destination *Calendar
+	LastSync    string
+	Options     LinkOptions
+	Status      LinkStatus
+}
+
+func (l Link) String() string {
+	return l.Source.String() + " -> " + l.Destination.String()
+}
+
+type LinkOptions struct {
+	IgnoreDeclinedEvents   bool `json:",omitempty"`
+	IgnoreMyEventsAlone    bool `json:",omitempty"`
+	IgnoreOutOfOfficeEvent bool `json:",omitempty"`
+	IgnoreFocusTimeEvent   bool `json:",omitempty"`
+	// Mirrors defines what to do with events in the source calendar
+	// that were created by us.
+	Mirrors MirrorPolicy `json:",omitempty"`
+	// BufferBefore and BufferAfter create busy events around each mirror,
+	// e.g. to have time to travel.
+	BufferBefore time.Duration `json:",omitempty"`
+	BufferAfter  time.Duration `json:",omitempty"`
+	// RSVP defines how the response to the event is shown on its mirror.
+	RSVP RSVPPolicy
+}
+
+// RSVPPolicy shows the response to an event on its mirror, instead of
+// mirroring all events as if they were accepted.
+type RSVPPolicy struct {
+	// Markers are added to the summary of the mirror, e.g. "(tentative)".
+	Markers StatusValues `json:",omitempty"`
+	// Colors are the color ids used by the mirror.
+	Colors StatusValues `json:",omitempty"`
+	// ShowAsFree makes the mirror of events declined or not answered yet
+	// don't block the time.
+	ShowAsFree bool `json:",omitempty"`
+}
+
+// StatusValues holds a value for each response status, it can be set
+// from a flag with "status=value" pairs separated by comma, e.g.
+// "tentative=?,needsAction=(?)".
+type StatusValues map[ResponseStatus]string
+
+func (v StatusValues) String() string {
+	pairs := make([]string, 0, len(v))
+	for status, value := range v {
+		pairs = append(pairs, status.String()+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v *StatusValues) Set(s string) error {
+	values := make(StatusValues)
+	for _, pair := range strings.Split(s, ",") {
+		status, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid value %q, expected status=value", pair)
+		}
+		switch ResponseStatus(status) {
+		case NeedsAction, Declined, Tentative, Accepted:
+		default:
+			return fmt.Errorf("invalid status %q, valid values are %s, %s, %s and %s", status, NeedsAction, Declined, Tentative, Accepted)
+		}
+		values[ResponseStatus(status)] = value
+	}
+	*v = values
+	return nil
+}
+
+type MirrorPolicy string
+
+var (
+	// MirrorsSkip doesn't mirror events created by us, it's the default
+	// when no policy is set.
+	MirrorsSkip MirrorPolicy = "skip"
+	// MirrorsPassThrough mirrors events created by us once more, keeping
+	// where they were originally mirrored from.
+	MirrorsPassThrough MirrorPolicy = "passthrough"
+)
+
+func (p MirrorPolicy) String() string {
+	return string(p)
+}
+
+func (p *MirrorPolicy) Set(v string) error {
+	switch MirrorPolicy(v) {
+	case MirrorsSkip, MirrorsPassThrough:
+		*p = MirrorPolicy(v)
+		return nil
+	}
+	return fmt.Errorf("invalid policy %q, valid values are %s and %s", v, MirrorsSkip, MirrorsPassThrough)
+}
+
+// LinkStats counts what is mirrored through a link.
+type LinkStats struct {
+	// Events mirrored, without their buffers.
+	Events int
+	// BusySources are the events keeping a busy destination busy.
+	BusySources    int
+	PendingRetries int
+	DeadRetries    int
+}
+
+type LinkStatus string
+
+func (s LinkStatus) String() string {
+	return string(s)
+}
+
+var (
+	LinkActive LinkStatus = "active"
+	LinkPaused LinkStatus = "paused"
+)
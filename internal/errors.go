@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"errors"
+	"time"
+)
+
+// TransientError is an error that might not happen if the operation is
+// tried again later. RetryAfter is how long the provider asked to wait,
+// if it did.
+type TransientError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// PermanentError is an error that will happen again no matter how many
+// times the operation is tried.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+func IsTransient(err error) bool {
+	var tErr *TransientError
+	return errors.As(err, &tErr)
+}
+
+func IsPermanent(err error) bool {
+	var pErr *PermanentError
+	return errors.As(err, &pErr)
+}
@@ -4,6 +4,7 @@ import "time"
 
 type Event struct {
 	ID             string
+	ICalUID        string
 	Type           EventType
 	Summary        string
 	Description    string
@@ -11,8 +12,63 @@ type Event struct {
 	EndsAt         time.Time
 	CreatedBy      string
 	CreatedByMe    bool
+	Organizer      string
 	ResponseStatus ResponseStatus
 	NumAttendees   int
+	// Transparent events don't block the time in the calendar.
+	Transparent bool
+	ColorID     string
+	Origin      *Origin
+}
+
+// Origin identifies the source event that an event was mirrored from,
+// it's stored by the providers together with the event. PassedThrough
+// is set when the event was mirrored from another mirror.
+type Origin struct {
+	CalendarID    string
+	EventID       string
+	PassedThrough bool
+}
+
+// EventMapping links an event in a destination calendar to the
+// source event it mirrors. Events created around a mirror, like buffers,
+// have the id of the mirror as ParentID.
+type EventMapping struct {
+	CalendarID    string
+	EventID       string
+	SrcCalendarID string
+	SrcEventID    string
+	ParentID      string
+	StartsAt      time.Time
+	EndsAt        time.Time
+}
+
+// BusyBlock is an event in a destination calendar covering the time the
+// source calendars are busy.
+type BusyBlock struct {
+	CalendarID string
+	EventID    string
+	StartsAt   time.Time
+	EndsAt     time.Time
+}
+
+// SourceEvent is the latest known state of an event of a source
+// calendar, as received by the syncer.
+type SourceEvent struct {
+	CalendarID string
+	Event      *Event
+	UpdatedAt  time.Time
+}
+
+// EventQuery filters the source events, empty fields match all events.
+// Events overlapping the interval between From and To are returned and
+// Text is searched in their summary and description.
+type EventQuery struct {
+	CalendarIDs []string
+	From        time.Time
+	To          time.Time
+	Text        string
+	Limit       int
 }
 
 type EventType string
@@ -2,10 +2,27 @@ package internal
 
 import (
 	"context"
+	"errors"
 
 	"golang.org/x/oauth2"
 )
 
+var (
+	// ErrInvalidSyncToken is returned by providers when the sync token can't
+	// be used anymore and all events must be listed again.
+	ErrInvalidSyncToken = errors.New("sync token is no longer valid")
+	// ErrAuthExpired is returned by providers when the auth of the account
+	// was revoked or expired, the account must log in again.
+	ErrAuthExpired = errors.New("account needs to authenticate again")
+)
+
+// AccountStorage is used by the providers to keep the auth of the
+// accounts up to date.
+type AccountStorage interface {
+	SaveAuth(context.Context, *Account) error
+	SetAuthStatus(_ context.Context, _ *Account, _ AuthStatus, reason string) error
+}
+
 type Mux interface {
 	Get(platform string) (Provider, error)
 }
@@ -15,6 +32,8 @@ type Provider interface {
 	Events(_ context.Context, _ *Calendar, from Date) (Iterator, error)
 	NewEventsFrom(_ context.Context, _ *Calendar, from Date) (Iterator, error)
 	NewEventsSince(_ context.Context, _ *Calendar, token string) (Iterator, error)
+	// Event returns the event, nil is returned if it doesn't exist.
+	Event(_ context.Context, _ *Calendar, id string) (*Event, error)
 	CreateEvent(_ context.Context, _ *Calendar, _ *Event) (*Event, error)
 	UpdateEvent(_ context.Context, _ *Calendar, _ *Event) error
 	DeleteEvent(_ context.Context, _ *Calendar, id string) error
@@ -26,3 +45,11 @@ type Iterator interface {
 	LastSync() string
 	Err() error
 }
+
+// PageIterator is implemented by iterators receiving the events in pages,
+// what is learned from the events of a page is saved at once.
+type PageIterator interface {
+	Iterator
+	// EndOfPage tells if the current event is the last one of its page.
+	EndOfPage() bool
+}
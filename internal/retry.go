@@ -0,0 +1,40 @@
+package internal
+
+import "time"
+
+type Operation string
+
+func (o Operation) String() string {
+	return string(o)
+}
+
+var (
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+type RetryStatus string
+
+func (s RetryStatus) String() string {
+	return string(s)
+}
+
+var (
+	RetryPending RetryStatus = "pending"
+	RetryDead    RetryStatus = "dead"
+)
+
+// Retry is an operation on a mirrored event that failed and will be
+// tried again on the next syncs. Event is the source event as it was
+// received from the provider.
+type Retry struct {
+	CalendarID    string
+	SrcCalendarID string
+	Operation     Operation
+	Event         *Event
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	Status        RetryStatus
+}
@@ -0,0 +1,84 @@
+package retry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket, it allows rate operations per second with
+// bursts of up to burst operations.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve()
+		if wait == 0 {
+			return nil
+		}
+		if err := Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token if there's one, otherwise returns how long until
+// the next one is available.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Limiters holds one limiter per key, e.g. per account.
+type Limiters struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    int
+	limiters map[string]*Limiter
+}
+
+func NewLimiters(rate float64, burst int) *Limiters {
+	return &Limiters{
+		rate:     rate,
+		burst:    burst,
+		limiters: make(map[string]*Limiter),
+	}
+}
+
+func (l *Limiters) Get(key string) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = NewLimiter(l.rate, l.burst)
+		l.limiters[key] = limiter
+	}
+	return limiter
+}
@@ -0,0 +1,54 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(1, 2)
+	for i := range 2 {
+		if d := l.reserve(); d != 0 {
+			t.Fatalf("reserve() #%d = %v, want 0", i+1, d)
+		}
+	}
+	if d := l.reserve(); d <= 0 || d > time.Second {
+		t.Fatalf("reserve() after burst = %v, want up to 1s", d)
+	}
+
+	// Tokens come back over time, up to the burst.
+	l.last = l.last.Add(-time.Hour)
+	for i := range 2 {
+		if d := l.reserve(); d != 0 {
+			t.Fatalf("reserve() #%d after refill = %v, want 0", i+1, d)
+		}
+	}
+	if d := l.reserve(); d == 0 {
+		t.Fatal("reserve() after refill = 0, want to wait once the burst is used")
+	}
+}
+
+func TestLimiterWaitCancelled(t *testing.T) {
+	l := NewLimiter(0.001, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLimitersGet(t *testing.T) {
+	l := NewLimiters(1, 1)
+	if l.Get("a") != l.Get("a") {
+		t.Error("Get() returned different limiters for the same key")
+	}
+	if l.Get("a") == l.Get("b") {
+		t.Error("Get() returned the same limiter for different keys")
+	}
+}
@@ -0,0 +1,67 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// Policy defines how many times and how long to wait between attempts
+// of an operation that failed with a transient error.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultPolicy = Policy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// Do calls fn until it succeeds, fails with an error that isn't
+// transient or the maximum number of attempts is reached.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !internal.IsTransient(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+		if err := Sleep(ctx, p.delay(attempt, err)); err != nil {
+			return err
+		}
+	}
+}
+
+// delay returns an exponential backoff with jitter, unless the error
+// asks to wait longer.
+func (p Policy) delay(attempt int, err error) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	d = d/2 + rand.N(d/2+1)
+
+	var tErr *internal.TransientError
+	if errors.As(err, &tErr) && tErr.RetryAfter > d {
+		d = tErr.RetryAfter
+	}
+	return d
+}
+
+// Sleep waits for d or until ctx is done.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+	other := errors.New("other")
+
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{"first attempt", 1, other, 500 * time.Millisecond, time.Second},
+		{"third attempt", 3, other, 2 * time.Second, 4 * time.Second},
+		{"max delay", 10, other, 30 * time.Second, time.Minute},
+		{"overflow", 100, other, 30 * time.Second, time.Minute},
+		{"retry after", 1, &internal.TransientError{Err: other, RetryAfter: 2 * time.Minute}, 2 * time.Minute, 2 * time.Minute},
+		{"short retry after", 3, &internal.TransientError{Err: other, RetryAfter: time.Millisecond}, 2 * time.Second, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				d := p.delay(tt.attempt, tt.err)
+				if d < tt.min || d > tt.max {
+					t.Fatalf("delay(%d) = %v, want between %v and %v", tt.attempt, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package internal
+
+import "time"
+
+// Run is a sync run and what happened with each link during it.
+type Run struct {
+	ID        int64
+	StartedAt time.Time
+	EndedAt   time.Time
+	Args      []string
+	// Error is set when the run stopped before syncing all links.
+	Error string
+	Links []*RunLink
+}
+
+// Failed checks if the run stopped or any of its links failed, even
+// partially.
+func (r *Run) Failed() bool {
+	if r.Error != "" {
+		return true
+	}
+	for _, l := range r.Links {
+		if l.Failed > 0 || len(l.Errors) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type RunLink struct {
+	LinkID        int64
+	Source        string
+	Destination   string
+	Created       int
+	Updated       int
+	Deleted       int
+	Skipped       int
+	Failed        int
+	Vetoed        int
+	Duration      time.Duration
+	TokenAdvanced bool
+	Errors        []string
+}
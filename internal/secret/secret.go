@@ -0,0 +1,159 @@
+// Package secret encrypts the credentials stored in the database.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// KeySize is the size in bytes of the keys, AES-256 is used.
+const KeySize = 32
+
+var (
+	ErrInvalidKey = errors.New("invalid key")
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// Key encrypts and decrypts data with AES-GCM, its id is derived from the
+// key so it can be stored together with the data.
+type Key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("%w: key must have %d bytes, got %d", ErrInvalidKey, KeySize, len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &Key{
+		id:   hex.EncodeToString(sum[:8]),
+		aead: aead,
+	}, nil
+}
+
+// ParseKey parses a base64 encoded key.
+func ParseKey(encoded string) (*Key, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return NewKey(raw)
+}
+
+// GenerateKey returns a new random key base64 encoded.
+func GenerateKey() (string, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// LoadKeyFile reads the key from filename, when the file doesn't exist and
+// create is set a new key is generated and written to it.
+func LoadKeyFile(filename string, create bool) (*Key, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		return CreateKeyFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(data))
+}
+
+// CreateKeyFile generates a new key and writes it to filename, which
+// must not exist.
+func CreateKeyFile(filename string) (*Key, error) {
+	encoded, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(f, encoded); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return ParseKey(encoded)
+}
+
+func (k *Key) ID() string {
+	return k.id
+}
+
+// Encrypt encrypts plaintext binding it to data, the same data must be
+// given to decrypt it.
+func (k *Key) Encrypt(plaintext, data []byte) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := k.aead.Seal(nonce, nonce, plaintext, data)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Key) Decrypt(ciphertext string, data []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	n := k.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	return k.aead.Open(nil, sealed[:n], sealed[n:], data)
+}
+
+// Keyring holds the key used to encrypt and all keys that can be used
+// to decrypt.
+type Keyring struct {
+	current *Key
+	keys    map[string]*Key
+}
+
+// NewKeyring returns a keyring encrypting with current, old keys are only
+// used to decrypt.
+func NewKeyring(current *Key, old ...*Key) *Keyring {
+	keys := map[string]*Key{current.ID(): current}
+	for _, k := range old {
+		keys[k.ID()] = k
+	}
+	return &Keyring{current: current, keys: keys}
+}
+
+func (r *Keyring) Current() *Key {
+	return r.current
+}
+
+// Key returns the key with the id.
+func (r *Keyring) Key(id string) (*Key, error) {
+	k, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return k, nil
+}
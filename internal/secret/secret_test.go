@@ -0,0 +1,147 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestKey(t *testing.T, b byte) *Key {
+	t.Helper()
+	key, err := NewKey(bytes.Repeat([]byte{b}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := newTestKey(t, 1)
+	plaintext := []byte(`{"access_token":"token"}`)
+	data := []byte("google/alice@example.com")
+
+	ciphertext, err := key.Encrypt(plaintext, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains([]byte(ciphertext), plaintext) {
+		t.Fatal("Encrypt() returned the plaintext")
+	}
+	other, err := key.Encrypt(plaintext, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == ciphertext {
+		t.Error("Encrypt() returned the same ciphertext twice")
+	}
+
+	tests := []struct {
+		name       string
+		key        *Key
+		ciphertext string
+		data       []byte
+		wantErr    bool
+	}{
+		{"same key and data", key, ciphertext, data, false},
+		{"other data", key, ciphertext, []byte("google/bob@example.com"), true},
+		{"other key", newTestKey(t, 2), ciphertext, data, true},
+		{"not base64", key, "not base64!", data, true},
+		{"too short", key, base64.StdEncoding.EncodeToString([]byte("short")), data, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.key.Decrypt(tt.ciphertext, tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Decrypt() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	a, b := newTestKey(t, 1), newTestKey(t, 2)
+	if a.ID() != newTestKey(t, 1).ID() {
+		t.Error("ID() differs for the same key")
+	}
+	if a.ID() == b.ID() {
+		t.Error("ID() is the same for different keys")
+	}
+	if len(a.ID()) != 16 {
+		t.Errorf("ID() = %q, want 16 hex characters", a.ID())
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"valid", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, KeySize)) + "\n", nil},
+		{"not base64", "not base64!", ErrInvalidKey},
+		{"too short", base64.StdEncoding.EncodeToString([]byte("short")), ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.encoded)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseKey() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && key.ID() != newTestKey(t, 1).ID() {
+				t.Errorf("ParseKey() returned key %s, want %s", key.ID(), newTestKey(t, 1).ID())
+			}
+		})
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.key")
+
+	if _, err := LoadKeyFile(filename, false); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadKeyFile() without create = %v, want %v", err, os.ErrNotExist)
+	}
+	created, err := LoadKeyFile(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadKeyFile(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID() != created.ID() {
+		t.Errorf("LoadKeyFile() = key %s, want the key created %s", loaded.ID(), created.ID())
+	}
+	if _, err := CreateKeyFile(filename); !errors.Is(err, os.ErrExist) {
+		t.Errorf("CreateKeyFile() on existing file = %v, want %v", err, os.ErrExist)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	current, old := newTestKey(t, 1), newTestKey(t, 2)
+	r := NewKeyring(current, old)
+
+	if r.Current() != current {
+		t.Error("Current() isn't the current key")
+	}
+	for _, k := range []*Key{current, old} {
+		got, err := r.Key(k.ID())
+		if err != nil || got != k {
+			t.Errorf("Key(%s) = %v, %v, want the key", k.ID(), got, err)
+		}
+	}
+	if _, err := r.Key(newTestKey(t, 3).ID()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Key() of unknown key = %v, want %v", err, ErrUnknownKey)
+	}
+}
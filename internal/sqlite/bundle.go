@@ -0,0 +1,372 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/secret"
+)
+
+// authNotImported is the auth error of the accounts imported without
+// auth, they must log in again.
+const authNotImported = "imported without auth"
+
+// Export returns all accounts, calendars, links and event mappings, with
+// the sources of dedup and busy calendars. When key is given the auth of
+// the accounts is exported encrypted with it.
+func (s Storage) Export(ctx context.Context, key *secret.Key) (*internal.Bundle, error) {
+	b := &internal.Bundle{
+		Version:    internal.BundleVersion,
+		ExportedAt: time.Now().UTC(),
+	}
+	if key != nil {
+		b.KeyID = key.ID()
+	}
+
+	var accounts []struct {
+		ID    string
+		Auth  string
+		KeyID string `db:"key_id"`
+	}
+	err := s.db.SelectContext(ctx, &accounts, `SELECT id, auth, key_id FROM accounts ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		acc := &internal.BundleAccount{ID: a.ID}
+		if key != nil {
+			auth, err := s.decryptAuth(a.ID, a.Auth, a.KeyID)
+			if err != nil {
+				return nil, err
+			}
+			acc.Auth, err = key.Encrypt([]byte(auth), []byte(a.ID))
+			if err != nil {
+				return nil, err
+			}
+		}
+		b.Accounts = append(b.Accounts, acc)
+	}
+
+	var cals []Calendar
+	err = s.db.SelectContext(ctx, &cals, `
+		SELECT account_id, name, provider_id, mode FROM calendars ORDER BY account_id, name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cals {
+		b.Calendars = append(b.Calendars, &internal.BundleCalendar{
+			AccountID:  c.AccountID,
+			Name:       c.Name,
+			ProviderID: c.ProviderID,
+			Mode:       internal.CalendarMode(c.Mode),
+		})
+	}
+
+	var links []struct {
+		Source      string `db:"src_calendar_id"`
+		Destination string `db:"dst_calendar_id"`
+		LastSync    string `db:"last_sync"`
+		Options     string
+		Status      string
+	}
+	err = s.db.SelectContext(ctx, &links, `
+		SELECT src_calendar_id, dst_calendar_id, last_sync, options, status FROM links ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		link := &internal.BundleLink{
+			Source:      l.Source,
+			Destination: l.Destination,
+			LastSync:    l.LastSync,
+			Status:      internal.LinkStatus(l.Status),
+		}
+		if err := json.Unmarshal([]byte(l.Options), &link.Options); err != nil {
+			return nil, fmt.Errorf("options of link %s -> %s: %w", l.Source, l.Destination, err)
+		}
+		b.Links = append(b.Links, link)
+	}
+
+	var mappings []EventMapping
+	err = s.db.SelectContext(ctx, &mappings, `
+		SELECT calendar_id, provider_id, src_calendar_id, src_provider_id, parent_id, starts_at, ends_at
+		FROM events
+		ORDER BY calendar_id, provider_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mappings {
+		b.Events = append(b.Events, &internal.BundleEvent{
+			CalendarID:    m.CalendarID,
+			EventID:       m.ProviderID,
+			SrcCalendarID: m.SrcCalendarID.String,
+			SrcEventID:    m.SrcProviderID,
+			ParentID:      m.ParentID,
+			StartsAt:      m.StartsAt.Time,
+			EndsAt:        m.EndsAt.Time,
+		})
+	}
+
+	var sources []struct {
+		EventMapping
+		DedupKey string `db:"dedup_key"`
+		Active   bool
+	}
+	err = s.db.SelectContext(ctx, &sources, `
+		SELECT calendar_id, provider_id, src_calendar_id, src_provider_id, dedup_key, active
+		FROM event_sources
+		ORDER BY calendar_id, src_calendar_id, src_provider_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range sources {
+		b.EventSources = append(b.EventSources, &internal.BundleEventSource{
+			CalendarID:    m.CalendarID,
+			EventID:       m.ProviderID,
+			SrcCalendarID: m.SrcCalendarID.String,
+			SrcEventID:    m.SrcProviderID,
+			DedupKey:      m.DedupKey,
+			Active:        m.Active,
+		})
+	}
+
+	var busy []EventMapping
+	err = s.db.SelectContext(ctx, &busy, `
+		SELECT calendar_id, src_calendar_id, src_provider_id, starts_at, ends_at
+		FROM busy_sources
+		ORDER BY calendar_id, src_calendar_id, src_provider_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range busy {
+		b.BusySources = append(b.BusySources, &internal.BundleBusySource{
+			CalendarID:    m.CalendarID,
+			SrcCalendarID: m.SrcCalendarID.String,
+			SrcEventID:    m.SrcProviderID,
+			StartsAt:      m.StartsAt.Time,
+			EndsAt:        m.EndsAt.Time,
+		})
+	}
+
+	var blocks []BusyBlock
+	err = s.db.SelectContext(ctx, &blocks, `
+		SELECT calendar_id, provider_id, starts_at, ends_at
+		FROM busy_blocks
+		ORDER BY calendar_id, provider_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, bb := range blocks {
+		b.BusyBlocks = append(b.BusyBlocks, &internal.BundleBusyBlock{
+			CalendarID: bb.CalendarID,
+			EventID:    bb.ProviderID,
+			StartsAt:   bb.StartsAt,
+			EndsAt:     bb.EndsAt,
+		})
+	}
+	return b, nil
+}
+
+// Import saves the bundle, key is used to decrypt the auth of the
+// accounts. The bundle is validated before anything is written and it's
+// imported in a single transaction.
+//
+// When replacing, everything not in the bundle is removed together with
+// what was derived from it, like retries and busy blocks. Accounts without
+// auth in the bundle keep the auth saved, new ones must log in again.
+func (s Storage) Import(ctx context.Context, b *internal.Bundle, mode internal.ImportMode, key *secret.Key) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	auths := make(map[string]string)
+	for _, a := range b.Accounts {
+		if a.Auth == "" {
+			continue
+		}
+		if key == nil {
+			return errors.New("the bundle has auth but no key was given")
+		}
+		if key.ID() != b.KeyID {
+			return fmt.Errorf("the bundle was encrypted with key %s, not %s", b.KeyID, key.ID())
+		}
+		auth, err := key.Decrypt(a.Auth, []byte(a.ID))
+		if err != nil {
+			return fmt.Errorf("decrypting auth of %s: %w", a.ID, err)
+		}
+		auths[a.ID] = string(auth)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if mode == internal.ImportReplace {
+		if err := clearImport(ctx, tx, b); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range b.Accounts {
+		auth, ok := auths[a.ID]
+		if !ok {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO accounts (id, auth, key_id, auth_status, auth_error, auth_updated_at) VALUES (?, "", "", ?, ?, ?)
+				ON CONFLICT(id) DO NOTHING
+			`, a.ID, internal.AuthExpired, authNotImported, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("account %s: %v", a.ID, err)
+			}
+			continue
+		}
+		sealed, keyID, err := s.sealAuth(a.ID, auth)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO accounts (id, auth, key_id, auth_status, auth_error, auth_updated_at) VALUES (?, ?, ?, ?, "", ?)
+			ON CONFLICT(id) DO UPDATE
+				SET auth = excluded.auth,
+					key_id = excluded.key_id,
+					auth_status = excluded.auth_status,
+					auth_error = "",
+					auth_updated_at = excluded.auth_updated_at;
+		`, a.ID, sealed, keyID, internal.AuthOK, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("account %s: %v", a.ID, err)
+		}
+	}
+
+	for _, c := range b.Calendars {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO calendars (account_id, name, provider_id, mode)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(account_id, name) DO UPDATE
+				SET provider_id = excluded.provider_id,
+					mode = excluded.mode;
+		`, c.AccountID, c.Name, c.ProviderID, c.Mode)
+		if err != nil {
+			return fmt.Errorf("calendar %s: %v", c.ID(), err)
+		}
+	}
+
+	for _, l := range b.Links {
+		opts, err := json.Marshal(l.Options)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO links (src_calendar_id, dst_calendar_id, last_sync, options, status)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(src_calendar_id, dst_calendar_id) DO UPDATE
+				SET last_sync = excluded.last_sync,
+					options = excluded.options,
+					status = excluded.status;
+		`, l.Source, l.Destination, l.LastSync, string(opts), l.Status)
+		if err != nil {
+			return fmt.Errorf("link %s -> %s: %v", l.Source, l.Destination, err)
+		}
+	}
+
+	for _, e := range b.Events {
+		if e.SrcCalendarID != "" {
+			// The source event can only be mirrored once in the calendar.
+			_, err = tx.ExecContext(ctx, `
+				DELETE FROM events
+				WHERE calendar_id = ? AND src_calendar_id = ? AND src_provider_id = ? AND provider_id != ?
+			`, e.CalendarID, e.SrcCalendarID, e.SrcEventID, e.EventID)
+			if err != nil {
+				return fmt.Errorf("event %s: %v", e.EventID, err)
+			}
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO events (calendar_id, provider_id, src_calendar_id, src_provider_id, parent_id, starts_at, ends_at)
+			VALUES (?, ?, NULLIF(?, ""), ?, ?, ?, ?)
+			ON CONFLICT(calendar_id, provider_id) DO UPDATE
+				SET src_calendar_id = excluded.src_calendar_id,
+					src_provider_id = excluded.src_provider_id,
+					parent_id = excluded.parent_id,
+					starts_at = excluded.starts_at,
+					ends_at = excluded.ends_at;
+		`, e.CalendarID, e.EventID, e.SrcCalendarID, e.SrcEventID, e.ParentID, nullTime(e.StartsAt), nullTime(e.EndsAt))
+		if err != nil {
+			return fmt.Errorf("event %s: %v", e.EventID, err)
+		}
+	}
+
+	for _, e := range b.EventSources {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO event_sources (calendar_id, provider_id, src_calendar_id, src_provider_id, dedup_key, active)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(calendar_id, src_calendar_id, src_provider_id) DO UPDATE
+				SET provider_id = excluded.provider_id,
+					dedup_key = excluded.dedup_key,
+					active = excluded.active;
+		`, e.CalendarID, e.EventID, e.SrcCalendarID, e.SrcEventID, e.DedupKey, e.Active)
+		if err != nil {
+			return fmt.Errorf("source %s of event %s: %v", e.SrcEventID, e.EventID, err)
+		}
+	}
+
+	for _, e := range b.BusySources {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO busy_sources (calendar_id, src_calendar_id, src_provider_id, starts_at, ends_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(calendar_id, src_calendar_id, src_provider_id) DO UPDATE
+				SET starts_at = excluded.starts_at,
+					ends_at = excluded.ends_at;
+		`, e.CalendarID, e.SrcCalendarID, e.SrcEventID, e.StartsAt.UTC(), e.EndsAt.UTC())
+		if err != nil {
+			return fmt.Errorf("busy source %s: %v", e.SrcEventID, err)
+		}
+	}
+
+	for _, e := range b.BusyBlocks {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO busy_blocks (calendar_id, provider_id, starts_at, ends_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(calendar_id, provider_id) DO UPDATE
+				SET starts_at = excluded.starts_at,
+					ends_at = excluded.ends_at;
+		`, e.CalendarID, e.EventID, e.StartsAt.UTC(), e.EndsAt.UTC())
+		if err != nil {
+			return fmt.Errorf("busy block %s: %v", e.EventID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// clearImport removes what is saved before replacing it by the bundle,
+// the accounts in the bundle are kept as they may keep their auth.
+func clearImport(ctx context.Context, tx *sqlx.Tx, b *internal.Bundle) error {
+	for _, table := range []string{"events", "event_sources", "busy_sources", "busy_blocks", "retries", "links", "calendars"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+			return fmt.Errorf("clearing %s: %v", table, err)
+		}
+	}
+
+	ids := make([]string, len(b.Accounts))
+	for i, a := range b.Accounts {
+		ids[i] = a.ID
+	}
+	query, args, err := sqlx.In(`DELETE FROM accounts WHERE id NOT IN (?)`, append(ids, ""))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, tx.Rebind(query), args...)
+	return err
+}
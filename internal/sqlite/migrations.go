@@ -1,32 +1,535 @@
 package sqlite
 
-func (s Storage) RunMigrations() error {
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// version of synccalendar.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// step changes the schema in tx, what it has to tell is written to output.
+type step func(ctx context.Context, tx *sqlx.Tx, output io.Writer) error
+
+// migration changes the schema from the previous version to version,
+// all its steps run in the same transaction.
+type migration struct {
+	version     int
+	description string
+	steps       []step
+}
+
+// MigrationStatus tells if the version was applied to the database.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   time.Time
+	// Unknown is set for versions applied by a newer binary.
+	Unknown bool
+}
+
+func (m MigrationStatus) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+func (s Storage) createMigrationsTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		description VARCHAR NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// SchemaVersion returns the version of the database and the latest
+// version known by this binary.
+func (s Storage) SchemaVersion(ctx context.Context) (current, latest int, err error) {
+	if err := s.createMigrationsTable(ctx); err != nil {
+		return 0, 0, err
+	}
+	var v sql.NullInt64
+	err = s.db.GetContext(ctx, &v, `SELECT MAX(version) FROM schema_migrations`)
+	return int(v.Int64), migrations[len(migrations)-1].version, err
+}
+
+// Migrate applies the migrations missing in the database, each one in its
+// own transaction, the rows dropped by them are reported to output. It
+// refuses to run against a database migrated by a newer binary.
+func (s Storage) Migrate(ctx context.Context, output io.Writer) error {
+	current, latest, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, latest)
+	}
+
 	for _, m := range migrations {
-		if _, err := s.db.Exec(m); err != nil {
+		if m.version <= current {
+			continue
+		}
+		if err := s.migrate(ctx, m, output); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// migrate applies the migration with foreign keys disabled, so tables can
+// be rebuilt without cascading to the tables referencing them. They are
+// checked before committing.
+func (s Storage) migrate(ctx context.Context, m migration, output io.Writer) error {
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Foreign keys can't be disabled inside a transaction.
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, step := range m.steps {
+		if err := step(ctx, tx, output); err != nil {
 			return err
 		}
 	}
+	if err := checkForeignKeys(ctx, tx); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)
+	`, m.version, m.description, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func checkForeignKeys(ctx context.Context, tx *sqlx.Tx) error {
+	var violations []struct {
+		Table  string        `db:"table"`
+		RowID  sql.NullInt64 `db:"rowid"`
+		Parent string        `db:"parent"`
+		FKID   int           `db:"fkid"`
+	}
+	if err := tx.SelectContext(ctx, &violations, `PRAGMA foreign_key_check`); err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		v := violations[0]
+		return fmt.Errorf("%d rows violate foreign keys, e.g. row %d of %s references a missing %s",
+			len(violations), v.RowID.Int64, v.Table, v.Parent)
+	}
 	return nil
 }
 
-var migrations = []string{
-	`CREATE TABLE IF NOT EXISTS accounts (
-		id VARCHAR NOT NULL PRIMARY KEY,
-		auth TEXT NOT NULL
-	)`,
-	`CREATE TABLE IF NOT EXISTS calendars (
-		account_id VARCHAR NOT NULL,
-		name VARCHAR NOT NULL,
-		provider_id VARCHAR NOT NULL,
-		last_sync VARCHAR NOT NULL DEFAULT "",
-		dst_calendar_id VARCHAR NULL DEFAULT NULL,
-		PRIMARY KEY (account_id, name),
-		FOREIGN KEY (account_id) REFERENCES accounts (id)
-	)`,
-	`CREATE TABLE IF NOT EXISTS events (
-		calendar_id VARCHAR NOT NULL,
-		provider_id VARCHAR NOT NULL,
-		src_provider_id VARCHAR NOT NULL,
-		PRIMARY KEY (calendar_id, provider_id)
-	)`,
+// MigrationStatus returns all migrations known by this binary or applied
+// to the database, ordered by version.
+func (s Storage) MigrationStatus(ctx context.Context) ([]*MigrationStatus, error) {
+	if err := s.createMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	var applied []struct {
+		Version     int       `db:"version"`
+		Description string    `db:"description"`
+		AppliedAt   time.Time `db:"applied_at"`
+	}
+	err := s.db.SelectContext(ctx, &applied, `
+		SELECT version, description, applied_at FROM schema_migrations ORDER BY version
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		res[i] = &MigrationStatus{Version: m.version, Description: m.description}
+	}
+	latest := migrations[len(migrations)-1].version
+	for _, a := range applied {
+		if a.Version > latest {
+			res = append(res, &MigrationStatus{
+				Version:     a.Version,
+				Description: a.Description,
+				AppliedAt:   a.AppliedAt,
+				Unknown:     true,
+			})
+			continue
+		}
+		for _, m := range res {
+			if m.Version == a.Version {
+				m.AppliedAt = a.AppliedAt
+			}
+		}
+	}
+	return res, nil
+}
+
+func exec(query string) step {
+	return func(ctx context.Context, tx *sqlx.Tx, _ io.Writer) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+// addColumn adds the column to the table when it doesn't exist yet,
+// SQLite doesn't support ADD COLUMN IF NOT EXISTS.
+func addColumn(table, column, definition string) step {
+	return func(ctx context.Context, tx *sqlx.Tx, _ io.Writer) error {
+		var n int
+		err := tx.GetContext(ctx, &n, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column)
+		if err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+definition)
+		return err
+	}
+}
+
+// rebuild recreates the table with a new definition, SQLite can't add
+// constraints to existing tables. Only the rows matching where are kept,
+// how many were dropped is written to output. The indexes must be created
+// again afterwards.
+func rebuild(table, definition, columns, where string) step {
+	return func(ctx context.Context, tx *sqlx.Tx, output io.Writer) error {
+		var total int64
+		if err := tx.GetContext(ctx, &total, `SELECT COUNT(*) FROM `+table); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `CREATE TABLE `+table+`_new (`+definition+`)`); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO `+table+`_new (`+columns+`) SELECT `+columns+` FROM `+table+` WHERE `+where)
+		if err != nil {
+			return err
+		}
+		kept, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if dropped := total - kept; dropped > 0 {
+			fmt.Fprintf(output, "Dropping %d rows of %s referencing rows that don't exist anymore\n", dropped, table)
+		}
+		for _, query := range []string{
+			`DROP TABLE ` + table,
+			`ALTER TABLE ` + table + `_new RENAME TO ` + table,
+		} {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// The first versions are idempotent, databases created before versioning
+// already have some of them applied.
+
+var migrations = []migration{
+	{1, "create accounts, calendars and events", []step{
+		exec(`CREATE TABLE IF NOT EXISTS accounts (
+			id VARCHAR NOT NULL PRIMARY KEY,
+			auth TEXT NOT NULL
+		)`),
+		exec(`CREATE TABLE IF NOT EXISTS calendars (
+			account_id VARCHAR NOT NULL,
+			name VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			last_sync VARCHAR NOT NULL DEFAULT "",
+			dst_calendar_id VARCHAR NULL DEFAULT NULL,
+			PRIMARY KEY (account_id, name),
+			FOREIGN KEY (account_id) REFERENCES accounts (id)
+		)`),
+		exec(`CREATE TABLE IF NOT EXISTS events (
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			PRIMARY KEY (calendar_id, provider_id)
+		)`),
+	}},
+	{2, "add source calendar to events", []step{
+		addColumn("events", "src_calendar_id", `VARCHAR NOT NULL DEFAULT ""`),
+	}},
+	{3, "create retries", []step{
+		exec(`CREATE TABLE IF NOT EXISTS retries (
+			calendar_id VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			operation VARCHAR NOT NULL,
+			event TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT "",
+			next_attempt_at DATETIME NOT NULL,
+			status VARCHAR NOT NULL DEFAULT "pending",
+			PRIMARY KEY (calendar_id, src_calendar_id, src_provider_id)
+		)`),
+	}},
+	{4, "add calendar mode and event sources", []step{
+		addColumn("calendars", "mode", `VARCHAR NOT NULL DEFAULT ""`),
+		exec(`CREATE TABLE IF NOT EXISTS event_sources (
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			dedup_key VARCHAR NOT NULL,
+			active BOOLEAN NOT NULL DEFAULT 1,
+			PRIMARY KEY (calendar_id, src_calendar_id, src_provider_id)
+		)`),
+		exec(`CREATE INDEX IF NOT EXISTS event_sources_dedup_key ON event_sources (calendar_id, dedup_key)`),
+	}},
+	{5, "move links to their own table", []step{
+		exec(`CREATE TABLE IF NOT EXISTS links (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			src_calendar_id VARCHAR NOT NULL,
+			dst_calendar_id VARCHAR NOT NULL,
+			last_sync VARCHAR NOT NULL DEFAULT "",
+			options TEXT NOT NULL DEFAULT "{}",
+			status VARCHAR NOT NULL DEFAULT "active",
+			UNIQUE (src_calendar_id, dst_calendar_id)
+		)`),
+		// Calendars used to be linked to only one destination through the
+		// dst_calendar_id column.
+		exec(`INSERT OR IGNORE INTO links (src_calendar_id, dst_calendar_id, last_sync)
+			SELECT account_id || "/" || name, dst_calendar_id, last_sync
+			FROM calendars
+			WHERE dst_calendar_id IS NOT NULL
+		`),
+		exec(`UPDATE calendars SET dst_calendar_id = NULL, last_sync = "" WHERE dst_calendar_id IS NOT NULL`),
+	}},
+	{6, "identify mappings by source calendar", []step{
+		// Events mapped before the source calendar was saved, when the
+		// destination has only one source there's no doubt where they
+		// came from.
+		exec(`UPDATE events SET src_calendar_id = (
+				SELECT l.src_calendar_id FROM links l WHERE l.dst_calendar_id = events.calendar_id
+			)
+			WHERE src_calendar_id = ""
+				AND (SELECT COUNT(*) FROM links l WHERE l.dst_calendar_id = events.calendar_id) = 1
+		`),
+		exec(`CREATE UNIQUE INDEX IF NOT EXISTS events_src_event
+			ON events (calendar_id, src_calendar_id, src_provider_id)
+			WHERE src_calendar_id != ""
+		`),
+	}},
+	{7, "add buffers to events", []step{
+		addColumn("events", "parent_id", `VARCHAR NOT NULL DEFAULT ""`),
+		addColumn("events", "starts_at", `DATETIME NULL DEFAULT NULL`),
+		addColumn("events", "ends_at", `DATETIME NULL DEFAULT NULL`),
+	}},
+	{8, "create busy sources and blocks", []step{
+		exec(`CREATE TABLE IF NOT EXISTS busy_sources (
+			calendar_id VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			starts_at DATETIME NOT NULL,
+			ends_at DATETIME NOT NULL,
+			PRIMARY KEY (calendar_id, src_calendar_id, src_provider_id)
+		)`),
+		exec(`CREATE TABLE IF NOT EXISTS busy_blocks (
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			starts_at DATETIME NOT NULL,
+			ends_at DATETIME NOT NULL,
+			PRIMARY KEY (calendar_id, provider_id)
+		)`),
+	}},
+	{9, "add encryption key to accounts", []step{
+		addColumn("accounts", "key_id", `VARCHAR NOT NULL DEFAULT ""`),
+	}},
+	{10, "add auth status to accounts", []step{
+		addColumn("accounts", "auth_status", `VARCHAR NOT NULL DEFAULT "ok"`),
+		addColumn("accounts", "auth_error", `TEXT NOT NULL DEFAULT ""`),
+		addColumn("accounts", "auth_updated_at", `DATETIME NULL DEFAULT NULL`),
+	}},
+	{11, "create runs", []step{
+		exec(`CREATE TABLE runs (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			started_at DATETIME NOT NULL,
+			ended_at DATETIME NULL DEFAULT NULL,
+			args TEXT NOT NULL DEFAULT "[]",
+			error TEXT NOT NULL DEFAULT ""
+		)`),
+		exec(`CREATE TABLE run_links (
+			run_id INTEGER NOT NULL,
+			link_id INTEGER NOT NULL,
+			source VARCHAR NOT NULL,
+			destination VARCHAR NOT NULL,
+			created INTEGER NOT NULL DEFAULT 0,
+			updated INTEGER NOT NULL DEFAULT 0,
+			deleted INTEGER NOT NULL DEFAULT 0,
+			skipped INTEGER NOT NULL DEFAULT 0,
+			failed INTEGER NOT NULL DEFAULT 0,
+			vetoed INTEGER NOT NULL DEFAULT 0,
+			duration INTEGER NOT NULL DEFAULT 0,
+			token_advanced BOOLEAN NOT NULL DEFAULT 0,
+			errors TEXT NOT NULL DEFAULT "[]",
+			FOREIGN KEY (run_id) REFERENCES runs (id) ON DELETE CASCADE
+		)`),
+		exec(`CREATE INDEX run_links_run_id ON run_links (run_id)`),
+	}},
+	{12, "create audit", []step{
+		exec(`CREATE TABLE audit (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER NOT NULL DEFAULT 0,
+			link_id INTEGER NOT NULL DEFAULT 0,
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			kind VARCHAR NOT NULL,
+			operation VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NOT NULL DEFAULT "",
+			src_provider_id VARCHAR NOT NULL DEFAULT "",
+			parent_id VARCHAR NOT NULL DEFAULT "",
+			before TEXT NULL DEFAULT NULL,
+			after TEXT NULL DEFAULT NULL,
+			created_at DATETIME NOT NULL,
+			undone_at DATETIME NULL DEFAULT NULL
+		)`),
+		exec(`CREATE INDEX audit_run_id ON audit (run_id)`),
+	}},
+	{13, "create source events", []step{
+		exec(`CREATE TABLE source_events (
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			summary TEXT NOT NULL DEFAULT "",
+			description TEXT NOT NULL DEFAULT "",
+			starts_at DATETIME NULL DEFAULT NULL,
+			ends_at DATETIME NULL DEFAULT NULL,
+			event TEXT NOT NULL,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (calendar_id, provider_id)
+		)`),
+		exec(`CREATE INDEX source_events_starts_at ON source_events (calendar_id, starts_at)`),
+	}},
+	{14, "enforce foreign keys", []step{
+		// The id of the calendar is what the other tables reference.
+		// Calendars of accounts that don't exist anymore are dropped, as
+		// everything referencing them.
+		rebuild("calendars", `
+			account_id VARCHAR NOT NULL,
+			name VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			last_sync VARCHAR NOT NULL DEFAULT "",
+			dst_calendar_id VARCHAR NULL DEFAULT NULL,
+			mode VARCHAR NOT NULL DEFAULT "",
+			id VARCHAR GENERATED ALWAYS AS (account_id || '/' || name) STORED UNIQUE,
+			PRIMARY KEY (account_id, name),
+			FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+		`, `account_id, name, provider_id, last_sync, dst_calendar_id, mode`,
+			`account_id IN (SELECT id FROM accounts)`),
+		rebuild("links", `
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			src_calendar_id VARCHAR NOT NULL,
+			dst_calendar_id VARCHAR NOT NULL,
+			last_sync VARCHAR NOT NULL DEFAULT "",
+			options TEXT NOT NULL DEFAULT "{}",
+			status VARCHAR NOT NULL DEFAULT "active",
+			UNIQUE (src_calendar_id, dst_calendar_id),
+			FOREIGN KEY (src_calendar_id) REFERENCES calendars (id) ON DELETE CASCADE,
+			FOREIGN KEY (dst_calendar_id) REFERENCES calendars (id) ON DELETE CASCADE
+		`, `id, src_calendar_id, dst_calendar_id, last_sync, options, status`,
+			`src_calendar_id IN (SELECT id FROM calendars) AND dst_calendar_id IN (SELECT id FROM calendars)`),
+		// Events mapped before their source calendar was saved have no
+		// source calendar, it's NULL so they don't reference any.
+		rebuild("events", `
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NULL DEFAULT NULL,
+			parent_id VARCHAR NOT NULL DEFAULT "",
+			starts_at DATETIME NULL DEFAULT NULL,
+			ends_at DATETIME NULL DEFAULT NULL,
+			PRIMARY KEY (calendar_id, provider_id),
+			FOREIGN KEY (calendar_id) REFERENCES calendars (id) ON DELETE CASCADE,
+			FOREIGN KEY (src_calendar_id) REFERENCES calendars (id) ON DELETE CASCADE
+		`, `calendar_id, provider_id, src_provider_id, src_calendar_id, parent_id, starts_at, ends_at`,
+			`calendar_id IN (SELECT id FROM calendars) AND (src_calendar_id = "" OR src_calendar_id IN (SELECT id FROM calendars))`),
+		exec(`UPDATE events SET src_calendar_id = NULL WHERE src_calendar_id = ""`),
+		exec(`CREATE UNIQUE INDEX events_src_event
+			ON events (calendar_id, src_calendar_id, src_provider_id)
+			WHERE src_calendar_id IS NOT NULL
+		`),
+		rebuild("event_sources", `
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			dedup_key VARCHAR NOT NULL,
+			active BOOLEAN NOT NULL DEFAULT 1,
+			PRIMARY KEY (calendar_id, src_calendar_id, src_provider_id),
+			FOREIGN KEY (calendar_id) REFERENCES calendars (id) ON DELETE CASCADE,
+			FOREIGN KEY (src_calendar_id) REFERENCES calendars (id) ON DELETE CASCADE
+		`, `calendar_id, provider_id, src_calendar_id, src_provider_id, dedup_key, active`,
+			`calendar_id IN (SELECT id FROM calendars) AND src_calendar_id IN (SELECT id FROM calendars)`),
+		exec(`CREATE INDEX event_sources_dedup_key ON event_sources (calendar_id, dedup_key)`),
+		rebuild("retries", `
+			calendar_id VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			operation VARCHAR NOT NULL,
+			event TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT "",
+			next_attempt_at DATETIME NOT NULL,
+			status VARCHAR NOT NULL DEFAULT "pending",
+			PRIMARY KEY (calendar_id, src_calendar_id, src_provider_id),
+			FOREIGN KEY (calendar_id) REFERENCES calendars (id) ON DELETE CASCADE,
+			FOREIGN KEY (src_calendar_id) REFERENCES calendars (id) ON DELETE CASCADE
+		`, `calendar_id, src_calendar_id, src_provider_id, operation, event, attempts, last_error, next_attempt_at, status`,
+			`calendar_id IN (SELECT id FROM calendars) AND src_calendar_id IN (SELECT id FROM calendars)`),
+		rebuild("busy_sources", `
+			calendar_id VARCHAR NOT NULL,
+			src_calendar_id VARCHAR NOT NULL,
+			src_provider_id VARCHAR NOT NULL,
+			starts_at DATETIME NOT NULL,
+			ends_at DATETIME NOT NULL,
+			PRIMARY KEY (calendar_id, src_calendar_id, src_provider_id),
+			FOREIGN KEY (calendar_id) REFERENCES calendars (id) ON DELETE CASCADE,
+			FOREIGN KEY (src_calendar_id) REFERENCES calendars (id) ON DELETE CASCADE
+		`, `calendar_id, src_calendar_id, src_provider_id, starts_at, ends_at`,
+			`calendar_id IN (SELECT id FROM calendars) AND src_calendar_id IN (SELECT id FROM calendars)`),
+		rebuild("busy_blocks", `
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			starts_at DATETIME NOT NULL,
+			ends_at DATETIME NOT NULL,
+			PRIMARY KEY (calendar_id, provider_id),
+			FOREIGN KEY (calendar_id) REFERENCES calendars (id) ON DELETE CASCADE
+		`, `calendar_id, provider_id, starts_at, ends_at`,
+			`calendar_id IN (SELECT id FROM calendars)`),
+		rebuild("source_events", `
+			calendar_id VARCHAR NOT NULL,
+			provider_id VARCHAR NOT NULL,
+			summary TEXT NOT NULL DEFAULT "",
+			description TEXT NOT NULL DEFAULT "",
+			starts_at DATETIME NULL DEFAULT NULL,
+			ends_at DATETIME NULL DEFAULT NULL,
+			event TEXT NOT NULL,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (calendar_id, provider_id),
+			FOREIGN KEY (calendar_id) REFERENCES calendars (id) ON DELETE CASCADE
+		`, `calendar_id, provider_id, summary, description, starts_at, ends_at, event, updated_at`,
+			`calendar_id IN (SELECT id FROM calendars)`),
+		exec(`CREATE INDEX source_events_starts_at ON source_events (calendar_id, starts_at)`),
+	}},
+	{15, "save mirrors", []step{
+		// The event as it was written in the destination, so it can be
+		// restored as it was.
+		exec(`ALTER TABLE events ADD COLUMN event TEXT NULL DEFAULT NULL`),
+	}},
 }
@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	latest := migrations[len(migrations)-1].version
+
+	tests := []struct {
+		name string
+		// version the database is at before inserting the data, -1 for
+		// databases created before versioning.
+		version int
+	}{
+		{"before versioning", -1},
+		{"version 1", 1},
+		{"before links", 4},
+		{"before foreign keys", 13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			s := NewStorage(db, nil)
+
+			if tt.version < 0 {
+				for _, step := range migrations[0].steps {
+					tx, err := s.db.BeginTxx(ctx, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := step(ctx, tx, io.Discard); err != nil {
+						t.Fatal(err)
+					}
+					if err := tx.Commit(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			} else {
+				if err := s.createMigrationsTable(ctx); err != nil {
+					t.Fatal(err)
+				}
+				for _, m := range migrations[:tt.version] {
+					if err := s.migrate(ctx, m, io.Discard); err != nil {
+						t.Fatalf("migration %d: %v", m.version, err)
+					}
+				}
+			}
+			insertTestData(t, s, tt.version)
+
+			var output strings.Builder
+			if err := s.Migrate(ctx, &output); err != nil {
+				t.Fatal(err)
+			}
+			current, _, err := s.SchemaVersion(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current != latest {
+				t.Errorf("SchemaVersion() = %d, want %d", current, latest)
+			}
+
+			work := testCalendar(alice, "work", "work@group.calendar.google.com")
+			personal := testCalendar(alice, "personal", "primary")
+			links, err := s.Links(ctx, personal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(links) != 1 || links[0].Source.ID != work.ID || links[0].LastSync != "token" {
+				t.Fatalf("Links() = %v, want the link from %s with its last sync", links, work)
+			}
+			id, err := s.DestinationEventID(ctx, personal, work, "e1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "m1" {
+				t.Errorf("DestinationEventID() = %q, want %q", id, "m1")
+			}
+			if tt.version >= 13 {
+				id, err := s.UnclaimedEventID(ctx, personal, "e2")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id != "m2" {
+					t.Errorf("UnclaimedEventID() = %q, want %q", id, "m2")
+				}
+				var n int
+				if err := s.db.GetContext(ctx, &n, `SELECT COUNT(*) FROM retries`); err != nil {
+					t.Fatal(err)
+				}
+				if n != 0 {
+					t.Errorf("%d retries of a missing source were kept", n)
+				}
+				if want := "Dropping 1 rows of retries"; !strings.Contains(output.String(), want) {
+					t.Errorf("Migrate() output = %q, want it to contain %q", output.String(), want)
+				}
+			}
+
+			// Nothing left to apply.
+			if err := s.Migrate(ctx, io.Discard); err != nil {
+				t.Fatalf("Migrate() again: %v", err)
+			}
+		})
+	}
+}
+
+// insertTestData saves the account of alice with her work calendar linked
+// to her personal calendar and one event mirrored, as the schema was at
+// the version. Since version 13 an unclaimed event and a retry of a
+// removed calendar are saved too.
+func insertTestData(t *testing.T, s *Storage, version int) {
+	t.Helper()
+
+	queries := []string{
+		`INSERT INTO accounts (id, auth) VALUES ('google/alice@example.com', '{}')`,
+		`INSERT INTO calendars (account_id, name, provider_id) VALUES ('google/alice@example.com', 'personal', 'primary')`,
+	}
+	if version < 5 {
+		queries = append(queries,
+			`INSERT INTO calendars (account_id, name, provider_id, last_sync, dst_calendar_id)
+			VALUES ('google/alice@example.com', 'work', 'work@group.calendar.google.com', 'token', 'google/alice@example.com/personal')`,
+		)
+	} else {
+		queries = append(queries,
+			`INSERT INTO calendars (account_id, name, provider_id) VALUES ('google/alice@example.com', 'work', 'work@group.calendar.google.com')`,
+			`INSERT INTO links (src_calendar_id, dst_calendar_id, last_sync) VALUES ('google/alice@example.com/work', 'google/alice@example.com/personal', 'token')`,
+		)
+	}
+	if version < 2 {
+		queries = append(queries,
+			`INSERT INTO events (calendar_id, provider_id, src_provider_id) VALUES ('google/alice@example.com/personal', 'm1', 'e1')`,
+		)
+	} else {
+		queries = append(queries,
+			`INSERT INTO events (calendar_id, provider_id, src_calendar_id, src_provider_id) VALUES ('google/alice@example.com/personal', 'm1', 'google/alice@example.com/work', 'e1')`,
+		)
+	}
+	if version >= 13 {
+		queries = append(queries,
+			`INSERT INTO events (calendar_id, provider_id, src_provider_id) VALUES ('google/alice@example.com/personal', 'm2', 'e2')`,
+			`INSERT INTO retries (calendar_id, src_calendar_id, src_provider_id, operation, event, next_attempt_at)
+			VALUES ('google/alice@example.com/personal', 'google/alice@example.com/gone', 'e3', 'create', '{}', '2024-01-01 00:00:00')`,
+		)
+	}
+	for _, q := range queries {
+		if _, err := s.db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+}
+
+func TestMigrateTooNew(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)
+	`, migrations[len(migrations)-1].version+1, "from the future", time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(ctx, io.Discard); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Migrate() = %v, want %v", err, ErrSchemaTooNew)
+	}
+}
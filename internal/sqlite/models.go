@@ -1,22 +1,45 @@
 package sqlite
 
 import (
+	"database/sql"
+	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/guilherme-santos/synccalendar/internal"
 )
 
+type Account struct {
+	ID         string
+	AuthStatus string `db:"auth_status"`
+	AuthError  string `db:"auth_error"`
+}
+
+func (a Account) Convert() *internal.Account {
+	acc := &internal.Account{
+		AuthStatus: internal.AuthStatus(a.AuthStatus),
+		AuthError:  a.AuthError,
+	}
+	acc.Platform, acc.Name, _ = strings.Cut(a.ID, "/")
+	return acc
+}
+
 type Calendar struct {
 	AccountID   string `db:"account_id"`
 	Name        string
 	ProviderID  string `db:"provider_id"`
-	LastSync    string `db:"last_sync"`
+	Mode        string
 	AccountAuth string `db:"auth"`
+	AuthKeyID   string `db:"key_id"`
+	AuthStatus  string `db:"auth_status"`
+	AuthError   string `db:"auth_error"`
 }
 
 func (c Calendar) Convert() *internal.Calendar {
 	acc := internal.Account{
-		Auth: c.AccountAuth,
+		Auth:       c.AccountAuth,
+		AuthStatus: internal.AuthStatus(c.AuthStatus),
+		AuthError:  c.AuthError,
 	}
 	acc.Platform, acc.Name, _ = strings.Cut(c.AccountID, "/")
 	return &internal.Calendar{
@@ -24,6 +47,224 @@ func (c Calendar) Convert() *internal.Calendar {
 		Name:       c.Name,
 		ProviderID: c.ProviderID,
 		Account:    acc,
-		LastSync:   c.LastSync,
+		Mode:       internal.CalendarMode(c.Mode),
+	}
+}
+
+type Link struct {
+	ID       int64
+	LastSync string `db:"last_sync"`
+	Options  string
+	Status   string
+	Calendar
+}
+
+// Convert returns the link, the embedded calendar is the source of the
+// link.
+func (l Link) Convert(dst *internal.Calendar) (*internal.Link, error) {
+	var opts internal.LinkOptions
+	if err := json.Unmarshal([]byte(l.Options), &opts); err != nil {
+		return nil, err
+	}
+	return &internal.Link{
+		ID:          l.ID,
+		Source:      l.Calendar.Convert(),
+		Destination: dst,
+		LastSync:    l.LastSync,
+		Options:     opts,
+		Status:      internal.LinkStatus(l.Status),
+	}, nil
+}
+
+type EventMapping struct {
+	CalendarID string `db:"calendar_id"`
+	ProviderID string `db:"provider_id"`
+	// SrcCalendarID is NULL for events mapped before their source
+	// calendar was saved.
+	SrcCalendarID sql.NullString `db:"src_calendar_id"`
+	SrcProviderID string         `db:"src_provider_id"`
+	ParentID      string         `db:"parent_id"`
+	StartsAt      sql.NullTime   `db:"starts_at"`
+	EndsAt        sql.NullTime   `db:"ends_at"`
+}
+
+func (m EventMapping) Convert() *internal.EventMapping {
+	return &internal.EventMapping{
+		CalendarID:    m.CalendarID,
+		EventID:       m.ProviderID,
+		SrcCalendarID: m.SrcCalendarID.String,
+		SrcEventID:    m.SrcProviderID,
+		ParentID:      m.ParentID,
+		StartsAt:      m.StartsAt.Time,
+		EndsAt:        m.EndsAt.Time,
+	}
+}
+
+type Retry struct {
+	CalendarID    string `db:"calendar_id"`
+	SrcCalendarID string `db:"src_calendar_id"`
+	SrcProviderID string `db:"src_provider_id"`
+	Operation     string
+	Event         string
+	Attempts      int
+	LastError     string    `db:"last_error"`
+	NextAttemptAt time.Time `db:"next_attempt_at"`
+	Status        string
+}
+
+func (r Retry) Convert() (*internal.Retry, error) {
+	var event *internal.Event
+	if err := json.Unmarshal([]byte(r.Event), &event); err != nil {
+		return nil, err
+	}
+	return &internal.Retry{
+		CalendarID:    r.CalendarID,
+		SrcCalendarID: r.SrcCalendarID,
+		Operation:     internal.Operation(r.Operation),
+		Event:         event,
+		Attempts:      r.Attempts,
+		LastError:     r.LastError,
+		NextAttemptAt: r.NextAttemptAt,
+		Status:        internal.RetryStatus(r.Status),
+	}, nil
+}
+
+type BusyBlock struct {
+	CalendarID string    `db:"calendar_id"`
+	ProviderID string    `db:"provider_id"`
+	StartsAt   time.Time `db:"starts_at"`
+	EndsAt     time.Time `db:"ends_at"`
+}
+
+func (b BusyBlock) Convert() *internal.BusyBlock {
+	return &internal.BusyBlock{
+		CalendarID: b.CalendarID,
+		EventID:    b.ProviderID,
+		StartsAt:   b.StartsAt,
+		EndsAt:     b.EndsAt,
+	}
+}
+
+type Run struct {
+	ID        int64
+	StartedAt time.Time    `db:"started_at"`
+	EndedAt   sql.NullTime `db:"ended_at"`
+	Args      string
+	Error     string
+}
+
+func (r Run) Convert() (*internal.Run, error) {
+	var args []string
+	if err := json.Unmarshal([]byte(r.Args), &args); err != nil {
+		return nil, err
+	}
+	return &internal.Run{
+		ID:        r.ID,
+		StartedAt: r.StartedAt,
+		EndedAt:   r.EndedAt.Time,
+		Args:      args,
+		Error:     r.Error,
+	}, nil
+}
+
+type RunLink struct {
+	RunID         int64 `db:"run_id"`
+	LinkID        int64 `db:"link_id"`
+	Source        string
+	Destination   string
+	Created       int
+	Updated       int
+	Deleted       int
+	Skipped       int
+	Failed        int
+	Vetoed        int
+	Duration      int64
+	TokenAdvanced bool `db:"token_advanced"`
+	Errors        string
+}
+
+func (l RunLink) Convert() (*internal.RunLink, error) {
+	var errs []string
+	if err := json.Unmarshal([]byte(l.Errors), &errs); err != nil {
+		return nil, err
+	}
+	return &internal.RunLink{
+		LinkID:        l.LinkID,
+		Source:        l.Source,
+		Destination:   l.Destination,
+		Created:       l.Created,
+		Updated:       l.Updated,
+		Deleted:       l.Deleted,
+		Skipped:       l.Skipped,
+		Failed:        l.Failed,
+		Vetoed:        l.Vetoed,
+		Duration:      time.Duration(l.Duration),
+		TokenAdvanced: l.TokenAdvanced,
+		Errors:        errs,
+	}, nil
+}
+
+type AuditEntry struct {
+	ID            int64
+	RunID         int64  `db:"run_id"`
+	LinkID        int64  `db:"link_id"`
+	CalendarID    string `db:"calendar_id"`
+	ProviderID    string `db:"provider_id"`
+	Kind          string
+	Operation     string
+	SrcCalendarID string         `db:"src_calendar_id"`
+	SrcProviderID string         `db:"src_provider_id"`
+	ParentID      string         `db:"parent_id"`
+	Before        sql.NullString `db:"before"`
+	After         sql.NullString `db:"after"`
+	CreatedAt     time.Time      `db:"created_at"`
+	UndoneAt      sql.NullTime   `db:"undone_at"`
+}
+
+func (a AuditEntry) Convert() (*internal.AuditEntry, error) {
+	var before, after *internal.Event
+	if a.Before.Valid {
+		if err := json.Unmarshal([]byte(a.Before.String), &before); err != nil {
+			return nil, err
+		}
+	}
+	if a.After.Valid {
+		if err := json.Unmarshal([]byte(a.After.String), &after); err != nil {
+			return nil, err
+		}
+	}
+	return &internal.AuditEntry{
+		ID:            a.ID,
+		RunID:         a.RunID,
+		LinkID:        a.LinkID,
+		CalendarID:    a.CalendarID,
+		EventID:       a.ProviderID,
+		Kind:          internal.AuditKind(a.Kind),
+		Operation:     internal.Operation(a.Operation),
+		SrcCalendarID: a.SrcCalendarID,
+		SrcEventID:    a.SrcProviderID,
+		ParentID:      a.ParentID,
+		Before:        before,
+		After:         after,
+		CreatedAt:     a.CreatedAt,
+		UndoneAt:      a.UndoneAt.Time,
+	}, nil
+}
+
+type SourceEvent struct {
+	CalendarID string    `db:"calendar_id"`
+	Event      string    `db:"event"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+func (e SourceEvent) Convert() (*internal.SourceEvent, error) {
+	var event *internal.Event
+	if err := json.Unmarshal([]byte(e.Event), &event); err != nil {
+		return nil, err
 	}
+	return &internal.SourceEvent{
+		CalendarID: e.CalendarID,
+		Event:      event,
+		UpdatedAt:  e.UpdatedAt,
+	}, nil
 }
@@ -3,66 +3,562 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/guilherme-santos/synccalendar/internal"
+	"github.com/guilherme-santos/synccalendar/internal/secret"
 	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
 )
 
-const DriverName = "sqlite3"
+// DriverName is the driver the database must be opened with. Every
+// connection has foreign keys enabled, uses WAL so readers don't block
+// the sync, and waits for the locks held by other processes instead of
+// failing right away.
+const DriverName = "sqlite3_synccalendar"
+
+// busyTimeout is how long, in milliseconds, a connection waits for a lock.
+const busyTimeout = 10000
+
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec(fmt.Sprintf(`
+				PRAGMA busy_timeout = %d;
+				PRAGMA foreign_keys = ON;
+				PRAGMA journal_mode = WAL;
+			`, busyTimeout), nil)
+			return err
+		},
+	})
+	sqlx.BindDriver(DriverName, sqlx.QUESTION)
+}
 
 type Storage struct {
-	db *sqlx.DB
+	db   *sqlx.DB
+	keys *secret.Keyring
+}
+
+// NewStorage returns a storage using db, which must be opened with
+// DriverName. Migrate has to be called before using it. The auth of the
+// accounts is encrypted with keys, when nil it's stored in plain text.
+func NewStorage(db *sql.DB, keys *secret.Keyring) *Storage {
+	return &Storage{
+		db:   sqlx.NewDb(db, DriverName),
+		keys: keys,
+	}
+}
+
+type txKey struct{}
+
+// queryer is what the storage needs to run queries, implemented by the
+// database and by transactions.
+type queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+// conn returns the transaction of the batch ctx belongs to, if any.
+func (s Storage) conn(ctx context.Context) queryer {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return s.db
 }
 
-func NewStorage(db *sql.DB) *Storage {
-	s := &Storage{
-		db: sqlx.NewDb(db, DriverName),
+// Batch calls fn with a context whose writes are made in a single
+// transaction, committed when fn returns without error.
+func (s Storage) Batch(ctx context.Context, fn func(context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
 	}
-	err := s.RunMigrations()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
-		panic(fmt.Sprintf("sqlite: running migrations: %v", err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
 	}
-	return s
+	return tx.Commit()
 }
 
 func (s Storage) AddAccount(ctx context.Context, account *internal.Account) error {
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO accounts (id, auth) VALUES (?, ?)
-		ON CONFLICT(id) DO UPDATE SET auth=?;
-	`, account.ID(), account.Auth, account.Auth)
+	auth, keyID, err := s.sealAuth(account.ID(), account.Auth)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO accounts (id, auth, key_id, auth_status, auth_error, auth_updated_at) VALUES (?, ?, ?, ?, "", ?)
+		ON CONFLICT(id) DO UPDATE
+			SET auth = excluded.auth,
+				key_id = excluded.key_id,
+				auth_status = excluded.auth_status,
+				auth_error = "",
+				auth_updated_at = excluded.auth_updated_at;
+	`, account.ID(), auth, keyID, internal.AuthOK, time.Now().UTC())
+	return err
+}
+
+// SaveAuth replaces the auth of the account, e.g. after it was refreshed.
+func (s Storage) SaveAuth(ctx context.Context, account *internal.Account) error {
+	auth, keyID, err := s.sealAuth(account.ID(), account.Auth)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `
+		UPDATE accounts SET auth = ?, key_id = ?, auth_updated_at = ? WHERE id = ?
+	`, auth, keyID, time.Now().UTC(), account.ID())
+	return err
+}
+
+func (s Storage) SetAuthStatus(ctx context.Context, account *internal.Account, status internal.AuthStatus, reason string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE accounts SET auth_status = ?, auth_error = ?, auth_updated_at = ? WHERE id = ?
+	`, status, reason, time.Now().UTC(), account.ID())
 	return err
 }
 
-func (s Storage) LinkCalendar(ctx context.Context, src, dst *internal.Calendar) error {
-	dstCalendarID := dst.Account.ID() + "/" + dst.Name
+// Accounts returns all accounts, their auth isn't loaded.
+func (s Storage) Accounts(ctx context.Context) ([]*internal.Account, error) {
+	var accounts []Account
+	err := s.conn(ctx).SelectContext(ctx, &accounts, `
+		SELECT id, auth_status, auth_error
+		FROM accounts
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.Account, len(accounts))
+	for i, a := range accounts {
+		res[i] = a.Convert()
+	}
+	return res, nil
+}
+
+// Account returns the account with its auth, nil is returned if it
+// doesn't exist.
+func (s Storage) Account(ctx context.Context, id string) (*internal.Account, error) {
+	var a struct {
+		Account
+		Auth  string
+		KeyID string `db:"key_id"`
+	}
+	err := s.conn(ctx).GetContext(ctx, &a, `
+		SELECT id, auth, key_id, auth_status, auth_error FROM accounts WHERE id = ?
+	`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	acc := a.Account.Convert()
+	acc.Auth, err = s.openAuth(ctx, a.ID, a.Auth, a.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// AccountCalendars returns the calendars of the account, its auth isn't
+// loaded.
+func (s Storage) AccountCalendars(ctx context.Context, acc *internal.Account) ([]*internal.Calendar, error) {
+	var cals []Calendar
+	err := s.conn(ctx).SelectContext(ctx, &cals, `
+		SELECT c.account_id, c.name, c.provider_id, c.mode, a.auth_status, a.auth_error
+		FROM calendars c
+		INNER JOIN accounts a ON a.id = c.account_id
+		WHERE c.account_id = ?
+		ORDER BY c.name
+	`, acc.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.Calendar, len(cals))
+	for i, c := range cals {
+		res[i] = c.Convert()
+	}
+	return res, nil
+}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+// DeleteAccount removes the account with its calendars, their links and
+// the mappings of their events, including the mappings kept in the
+// calendars of other accounts. The calendars linked to them are removed
+// as well when no other link uses them.
+func (s Storage) DeleteAccount(ctx context.Context, acc *internal.Account) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO calendars (account_id, name, provider_id, dst_calendar_id)
+	var linked []string
+	err = tx.SelectContext(ctx, &linked, `
+		SELECT src_calendar_id FROM links WHERE dst_calendar_id IN (SELECT id FROM calendars WHERE account_id = ?)
+		UNION
+		SELECT dst_calendar_id FROM links WHERE src_calendar_id IN (SELECT id FROM calendars WHERE account_id = ?)
+	`, acc.ID(), acc.ID())
+	if err != nil {
+		return err
+	}
+	// The calendars, links and mappings are deleted by the foreign keys.
+	res, err := tx.ExecContext(ctx, `DELETE FROM accounts WHERE id = ?`, acc.ID())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("account %s not found", acc.ID())
+	}
+	if len(linked) > 0 {
+		query, args, err := sqlx.In(`
+			DELETE FROM calendars
+			WHERE id IN (?)
+				AND id NOT IN (SELECT src_calendar_id FROM links UNION SELECT dst_calendar_id FROM links)
+		`, linked)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sealAuth encrypts the auth of the account with the current key.
+func (s Storage) sealAuth(accountID, auth string) (string, string, error) {
+	if s.keys == nil {
+		return auth, "", nil
+	}
+	key := s.keys.Current()
+	sealed, err := key.Encrypt([]byte(auth), []byte(accountID))
+	return sealed, key.ID(), err
+}
+
+// openAuth decrypts the auth of the account, auth saved in plain text is
+// encrypted on the way.
+func (s Storage) openAuth(ctx context.Context, accountID, auth, keyID string) (string, error) {
+	if keyID == "" {
+		if s.keys == nil {
+			return auth, nil
+		}
+		sealed, keyID, err := s.sealAuth(accountID, auth)
+		if err == nil {
+			_, err = s.conn(ctx).ExecContext(ctx, `
+				UPDATE accounts SET auth = ?, key_id = ? WHERE id = ? AND key_id = ""
+			`, sealed, keyID, accountID)
+		}
+		if err != nil {
+			return "", fmt.Errorf("encrypting auth of %s: %w", accountID, err)
+		}
+		return auth, nil
+	}
+	return s.decryptAuth(accountID, auth, keyID)
+}
+
+func (s Storage) decryptAuth(accountID, auth, keyID string) (string, error) {
+	if keyID == "" {
+		return auth, nil
+	}
+	if s.keys == nil {
+		return "", fmt.Errorf("auth of %s is encrypted but no key was given", accountID)
+	}
+	key, err := s.keys.Key(keyID)
+	if err != nil {
+		return "", fmt.Errorf("decrypting auth of %s: %w", accountID, err)
+	}
+	plaintext, err := key.Decrypt(auth, []byte(accountID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting auth of %s: %w", accountID, err)
+	}
+	return string(plaintext), nil
+}
+
+// calendar converts c decrypting the auth of its account.
+func (s Storage) calendar(ctx context.Context, c Calendar) (*internal.Calendar, error) {
+	cal := c.Convert()
+
+	var err error
+	cal.Account.Auth, err = s.openAuth(ctx, c.AccountID, c.AccountAuth, c.AuthKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return cal, nil
+}
+
+// RotateKey encrypts the auth of all accounts with key, it returns how
+// many accounts were encrypted again. Accounts already encrypted with key
+// are kept as they are.
+func (s Storage) RotateKey(ctx context.Context, key *secret.Key) (int, error) {
+	return s.encryptAccounts(ctx, key, false)
+}
+
+// EncryptAccounts encrypts the auth of the accounts saved in plain text
+// with the current key.
+func (s Storage) EncryptAccounts(ctx context.Context) (int, error) {
+	if s.keys == nil {
+		return 0, nil
+	}
+	return s.encryptAccounts(ctx, s.keys.Current(), true)
+}
+
+// EncryptedAccounts returns how many accounts have their auth encrypted.
+func (s Storage) EncryptedAccounts(ctx context.Context) (int, error) {
+	var n int
+	err := s.conn(ctx).GetContext(ctx, &n, `SELECT COUNT(*) FROM accounts WHERE key_id != ""`)
+	return n, err
+}
+
+func (s Storage) encryptAccounts(ctx context.Context, key *secret.Key, plaintextOnly bool) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var accounts []struct {
+		ID    string
+		Auth  string
+		KeyID string `db:"key_id"`
+	}
+	err = tx.SelectContext(ctx, &accounts, `SELECT id, auth, key_id FROM accounts`)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, a := range accounts {
+		if a.KeyID == key.ID() || (plaintextOnly && a.KeyID != "") {
+			continue
+		}
+		auth, err := s.decryptAuth(a.ID, a.Auth, a.KeyID)
+		if err != nil {
+			return 0, err
+		}
+		sealed, err := key.Encrypt([]byte(auth), []byte(a.ID))
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE accounts SET auth = ?, key_id = ? WHERE id = ?
+		`, sealed, key.ID(), a.ID)
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, tx.Commit()
+}
+
+// LinkCalendar saves both calendars and links them, if the link already
+// exists its options are updated.
+func (s Storage) LinkCalendar(ctx context.Context, link *internal.Link) error {
+	opts, err := json.Marshal(link.Options)
+	if err != nil {
+		return err
+	}
+	status := link.Status
+	if status == "" {
+		status = internal.LinkActive
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if link.Options.Mirrors == "" {
+		cycle, err := createsCycle(ctx, tx, link)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return fmt.Errorf("%w, set a policy for mirrored events to link them anyway", internal.ErrLinkCycle)
+		}
+	}
+
+	for _, cal := range []*internal.Calendar{link.Source, link.Destination} {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO calendars (account_id, name, provider_id)
+			VALUES (?, ?, ?)
+			ON CONFLICT(account_id, name) DO UPDATE
+				SET provider_id = ?;
+		`, cal.Account.ID(), cal.Name, cal.ProviderID, cal.ProviderID)
+		if err != nil {
+			return fmt.Errorf("calendar %s: %v", cal, err)
+		}
+	}
+
+	err = tx.GetContext(ctx, &link.ID, `
+		INSERT INTO links (src_calendar_id, dst_calendar_id, options, status)
 		VALUES (?, ?, ?, ?)
-		ON CONFLICT(account_id, name) DO UPDATE
-			SET dst_calendar_id = ?;
-	`, src.Account.ID(), src.Name, src.ProviderID, dstCalendarID, dstCalendarID)
+		ON CONFLICT(src_calendar_id, dst_calendar_id) DO UPDATE
+			SET options = excluded.options,
+				status = excluded.status
+		RETURNING id;
+	`, calendarID(link.Source), calendarID(link.Destination), string(opts), status)
+	if err != nil {
+		return fmt.Errorf("link: %v", err)
+	}
+	link.Status = status
+	return tx.Commit()
+}
+
+// createsCycle checks if events mirrored through the link could end up
+// back in the source calendar.
+func createsCycle(ctx context.Context, tx *sqlx.Tx, link *internal.Link) (bool, error) {
+	var edges []struct {
+		SrcAccountID  string `db:"src_account_id"`
+		SrcProviderID string `db:"src_provider_id"`
+		DstAccountID  string `db:"dst_account_id"`
+		DstProviderID string `db:"dst_provider_id"`
+	}
+	err := tx.SelectContext(ctx, &edges, `
+		SELECT s.account_id AS src_account_id, s.provider_id AS src_provider_id,
+			d.account_id AS dst_account_id, d.provider_id AS dst_provider_id
+		FROM links l
+		INNER JOIN calendars s ON s.account_id || "/" || s.name = l.src_calendar_id
+		INNER JOIN calendars d ON d.account_id || "/" || d.name = l.dst_calendar_id
+		WHERE l.src_calendar_id != ? OR l.dst_calendar_id != ?
+	`, calendarID(link.Source), calendarID(link.Destination))
 	if err != nil {
-		return fmt.Errorf("source calendar: %v", err)
+		return false, err
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO calendars (account_id, name, provider_id)
-		VALUES (?, ?, ?)
-		ON CONFLICT(account_id, name) DO UPDATE
-			SET provider_id = ?;
-	`, dst.Account.ID(), dst.Name, dst.ProviderID, dst.ProviderID)
+	graph := make(map[string][]string)
+	for _, e := range edges {
+		from := calendarNode(e.SrcAccountID, e.SrcProviderID)
+		graph[from] = append(graph[from], calendarNode(e.DstAccountID, e.DstProviderID))
+	}
+
+	src := calendarNode(link.Source.Account.ID(), link.Source.ProviderID)
+	visited := make(map[string]bool)
+	pending := []string{calendarNode(link.Destination.Account.ID(), link.Destination.ProviderID)}
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if node == src {
+			return true, nil
+		}
+		if visited[node] {
+			continue
+		}
+		visited[node] = true
+		pending = append(pending, graph[node]...)
+	}
+	return false, nil
+}
+
+// calendarNode identifies the calendar on the provider, different
+// calendars on our side can point to the same calendar on the provider.
+func calendarNode(accountID, providerID string) string {
+	platform, name, _ := strings.Cut(accountID, "/")
+	if providerID == "primary" {
+		// The primary calendar has the same id as the account.
+		providerID = name
+	}
+	return platform + "/" + providerID
+}
+
+// calendarID returns the id used to reference the calendar on the other
+// tables.
+func calendarID(cal *internal.Calendar) string {
+	return cal.Account.ID() + "/" + cal.Name
+}
+
+func (s Storage) SetCalendarMode(ctx context.Context, cal *internal.Calendar, mode internal.CalendarMode) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE calendars SET mode = ? WHERE account_id = ? AND name = ?
+	`, mode, cal.Account.ID(), cal.Name)
+	return err
+}
+
+// Calendar returns the calendar with the auth of its account, nil is
+// returned if it doesn't exist.
+func (s Storage) Calendar(ctx context.Context, id string) (*internal.Calendar, error) {
+	var c Calendar
+	err := s.conn(ctx).GetContext(ctx, &c, `
+		SELECT c.account_id, c.name, c.provider_id, c.mode, a.auth, a.key_id, a.auth_status, a.auth_error
+		FROM calendars c
+		INNER JOIN accounts a ON a.id = c.account_id
+		WHERE c.id = ?
+	`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.calendar(ctx, c)
+}
+
+// RenameCalendar changes the name of the calendar, and so its id, on all
+// links and mappings. The mirrors keep the previous name until they are
+// rendered again.
+func (s Storage) RenameCalendar(ctx context.Context, cal *internal.Calendar, name string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The id is referenced by the other tables, they are checked once
+	// all of them were changed.
+	if _, err := tx.ExecContext(ctx, `PRAGMA defer_foreign_keys = ON`); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE calendars SET name = ? WHERE account_id = ? AND name = ?
+	`, name, cal.Account.ID(), cal.Name)
 	if err != nil {
-		return fmt.Errorf("destination calendar: %v", err)
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("calendar %s not found", cal)
+	}
+
+	newID := cal.Account.ID() + "/" + name
+	for _, column := range []string{
+		"links.src_calendar_id",
+		"links.dst_calendar_id",
+		"events.calendar_id",
+		"events.src_calendar_id",
+		"event_sources.calendar_id",
+		"event_sources.src_calendar_id",
+		"retries.calendar_id",
+		"retries.src_calendar_id",
+		"busy_sources.calendar_id",
+		"busy_sources.src_calendar_id",
+		"busy_blocks.calendar_id",
+		"source_events.calendar_id",
+		"audit.calendar_id",
+		"audit.src_calendar_id",
+	} {
+		table, column, _ := strings.Cut(column, ".")
+		_, err := tx.ExecContext(ctx, `UPDATE `+table+` SET `+column+` = ? WHERE `+column+` = ?`, newID, cal.ID)
+		if err != nil {
+			return fmt.Errorf("renaming %s of %s: %v", column, table, err)
+		}
 	}
 	return tx.Commit()
 }
@@ -82,74 +578,942 @@ func (s Storage) DestinationCalendars(ctx context.Context, calIDs []string) ([]*
 
 	var cals []Calendar
 
-	err := s.db.SelectContext(ctx, &cals, `
-		SELECT c.account_id, c.name, c.provider_id, a.auth
+	err := s.conn(ctx).SelectContext(ctx, &cals, `
+		SELECT c.account_id, c.name, c.provider_id, c.mode, a.auth, a.key_id, a.auth_status, a.auth_error
 		FROM calendars c
 		INNER JOIN accounts a ON a.id = c.account_id
-		WHERE dst_calendar_id IS NULL
-			AND `+strings.Join(orWhere, " OR "), args...)
+		WHERE c.account_id || "/" || c.name IN (SELECT dst_calendar_id FROM links)
+			AND (`+strings.Join(orWhere, " OR ")+`)`, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	res := make([]*internal.Calendar, len(cals))
 	for i, c := range cals {
-		res[i] = c.Convert()
+		res[i], err = s.calendar(ctx, c)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return res, nil
 }
 
-func (s Storage) SourceCalendars(ctx context.Context, dstCalID string) ([]*internal.Calendar, error) {
-	var cals []Calendar
+// Links returns the links whose destination is dst.
+func (s Storage) Links(ctx context.Context, dst *internal.Calendar) ([]*internal.Link, error) {
+	var links []Link
 
-	err := s.db.SelectContext(ctx, &cals, `
-		SELECT c.account_id, c.name, c.provider_id, c.last_sync, a.auth
-		FROM calendars c
+	err := s.conn(ctx).SelectContext(ctx, &links, `
+		SELECT l.id, l.last_sync, l.options, l.status,
+			c.account_id, c.name, c.provider_id, c.mode, a.auth, a.key_id, a.auth_status, a.auth_error
+		FROM links l
+		INNER JOIN calendars c ON c.account_id || "/" || c.name = l.src_calendar_id
 		INNER JOIN accounts a ON a.id = c.account_id
-		WHERE dst_calendar_id  = ?
-	`, dstCalID)
+		WHERE l.dst_calendar_id = ?
+		ORDER BY l.id
+	`, dst.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	res := make([]*internal.Calendar, len(cals))
-	for i, c := range cals {
-		res[i] = c.Convert()
+	res := make([]*internal.Link, len(links))
+	for i, l := range links {
+		res[i], err = l.Convert(dst)
+		if err == nil {
+			res[i].Source, err = s.calendar(ctx, l.Calendar)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 	return res, nil
 }
 
-func (s Storage) DestinationEventID(ctx context.Context, cal *internal.Calendar, srcEventID string) (string, error) {
-	var providerID string
-	err := s.db.GetContext(ctx, &providerID, `
-		SELECT provider_id
-		FROM events
-		WHERE calendar_id = ? AND src_provider_id = ?
-	`, cal.ID, srcEventID)
+// Link returns the link with its calendars, nil is returned if it doesn't
+// exist.
+func (s Storage) Link(ctx context.Context, id int64) (*internal.Link, error) {
+	var l struct {
+		Link
+		Destination string `db:"dst_calendar_id"`
+	}
+	err := s.conn(ctx).GetContext(ctx, &l, `
+		SELECT l.id, l.last_sync, l.options, l.status, l.dst_calendar_id,
+			c.account_id, c.name, c.provider_id, c.mode, a.auth, a.key_id, a.auth_status, a.auth_error
+		FROM links l
+		INNER JOIN calendars c ON c.id = l.src_calendar_id
+		INNER JOIN accounts a ON a.id = c.account_id
+		WHERE l.id = ?
+	`, id)
 	if errors.Is(err, sql.ErrNoRows) {
-		err = nil
+		return nil, nil
 	}
-	return providerID, err
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := s.Calendar(ctx, l.Destination)
+	if err != nil {
+		return nil, err
+	}
+	link, err := l.Link.Convert(dst)
+	if err != nil {
+		return nil, err
+	}
+	link.Source, err = s.calendar(ctx, l.Calendar)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
 }
 
-func (s Storage) CreateEvent(ctx context.Context, cal *internal.Calendar, dstEventID, srcEventID string) error {
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO events (calendar_id, provider_id, src_provider_id)
-		VALUES (?, ?, ?)
-	`, cal.ID, dstEventID, srcEventID)
+func (s Storage) SetLinkStatus(ctx context.Context, link *internal.Link, status internal.LinkStatus) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE links SET status = ? WHERE id = ?`, status, link.ID)
 	return err
 }
 
-func (s Storage) DeleteEvent(ctx context.Context, cal *internal.Calendar, eventID string) error {
-	_, err := s.db.ExecContext(ctx, `
-		DELETE FROM events WHERE calendar_id = ? AND provider_id = ?
-	`, cal.ID, eventID)
-	return err
+// LinkStats returns how many events are mirrored through the link and how
+// many are waiting to be tried again.
+func (s Storage) LinkStats(ctx context.Context, link *internal.Link) (*internal.LinkStats, error) {
+	var stats internal.LinkStats
+	err := s.conn(ctx).GetContext(ctx, &stats.Events, `
+		SELECT COUNT(*) FROM events WHERE calendar_id = ? AND src_calendar_id = ? AND parent_id = ""
+	`, link.Destination.ID, link.Source.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.conn(ctx).GetContext(ctx, &stats.BusySources, `
+		SELECT COUNT(*) FROM busy_sources WHERE calendar_id = ? AND src_calendar_id = ?
+	`, link.Destination.ID, link.Source.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.conn(ctx).GetContext(ctx, &stats.PendingRetries, `
+		SELECT COUNT(*) FROM retries WHERE calendar_id = ? AND src_calendar_id = ? AND status = ?
+	`, link.Destination.ID, link.Source.ID, internal.RetryPending)
+	if err != nil {
+		return nil, err
+	}
+	err = s.conn(ctx).GetContext(ctx, &stats.DeadRetries, `
+		SELECT COUNT(*) FROM retries WHERE calendar_id = ? AND src_calendar_id = ? AND status = ?
+	`, link.Destination.ID, link.Source.ID, internal.RetryDead)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
 }
 
-func (s Storage) SaveLastSync(ctx context.Context, cal *internal.Calendar, lastSync string) error {
-	_, err := s.db.ExecContext(ctx, `
-		UPDATE calendars SET last_sync = ? WHERE account_id = ? AND name = ?
-	`, lastSync, cal.Account.ID(), cal.Name)
-	return err
+// DeleteLink removes the link and the mappings of its events. Its
+// calendars are removed as well when no other link uses them.
+func (s Storage) DeleteLink(ctx context.Context, link *internal.Link) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM links WHERE id = ?`, link.ID); err != nil {
+		return err
+	}
+	for _, table := range []string{"events", "event_sources", "retries", "busy_sources"} {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM `+table+` WHERE calendar_id = ? AND src_calendar_id = ?
+		`, link.Destination.ID, link.Source.ID)
+		if err != nil {
+			return fmt.Errorf("deleting %s: %v", table, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM calendars
+		WHERE id IN (?, ?)
+			AND id NOT IN (SELECT src_calendar_id FROM links UNION SELECT dst_calendar_id FROM links)
+	`, link.Source.ID, link.Destination.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DestinationEventID returns the id of the event in dst that mirrors the
+// source event, an empty id is returned if there's none.
+func (s Storage) DestinationEventID(ctx context.Context, dst, src *internal.Calendar, srcEventID string) (string, error) {
+	var id string
+	err := s.conn(ctx).GetContext(ctx, &id, `
+		SELECT provider_id FROM events
+		WHERE calendar_id = ? AND src_calendar_id = ? AND src_provider_id = ?
+	`, dst.ID, src.ID, srcEventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
+// UnclaimedEventID returns the id of the event in dst mirroring the source
+// event that was mapped before its source calendar was saved, an empty id
+// is returned if there's none.
+func (s Storage) UnclaimedEventID(ctx context.Context, dst *internal.Calendar, srcEventID string) (string, error) {
+	var id string
+	err := s.conn(ctx).GetContext(ctx, &id, `
+		SELECT provider_id FROM events
+		WHERE calendar_id = ? AND src_calendar_id IS NULL AND src_provider_id = ?
+		LIMIT 1
+	`, dst.ID, srcEventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
+// ClaimEvent assigns to src the event of dst mapped before its source
+// calendar was saved.
+func (s Storage) ClaimEvent(ctx context.Context, dst, src *internal.Calendar, dstEventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE events SET src_calendar_id = ? WHERE calendar_id = ? AND provider_id = ? AND src_calendar_id IS NULL
+	`, src.ID, dst.ID, dstEventID)
+	return err
+}
+
+// EventMappings returns the events mirrored from src into dst, if src is
+// nil the events mirrored from all sources are returned.
+func (s Storage) EventMappings(ctx context.Context, dst, src *internal.Calendar) ([]*internal.EventMapping, error) {
+	where := "calendar_id = ?"
+	args := []interface{}{dst.ID}
+	if src != nil {
+		where += " AND src_calendar_id = ?"
+		args = append(args, src.ID)
+	}
+
+	var mappings []EventMapping
+
+	err := s.conn(ctx).SelectContext(ctx, &mappings, `
+		SELECT calendar_id, provider_id, src_calendar_id, src_provider_id, parent_id, starts_at, ends_at
+		FROM events
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.EventMapping, len(mappings))
+	for i, m := range mappings {
+		res[i] = m.Convert()
+	}
+	return res, nil
+}
+
+// ClaimEvents assigns to src the events of dst mapped before their
+// source calendar was saved.
+func (s Storage) ClaimEvents(ctx context.Context, dst, src *internal.Calendar) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE events SET src_calendar_id = ?
+		WHERE calendar_id = ? AND src_calendar_id IS NULL AND src_provider_id NOT IN (
+			SELECT src_provider_id FROM events WHERE calendar_id = ? AND src_calendar_id = ?
+		)
+	`, src.ID, dst.ID, dst.ID, src.ID)
+	return err
+}
+
+// EventMapping returns the mapping of the event in cal, nil is returned
+// if the event isn't mapped.
+func (s Storage) EventMapping(ctx context.Context, cal *internal.Calendar, eventID string) (*internal.EventMapping, error) {
+	var m EventMapping
+	err := s.conn(ctx).GetContext(ctx, &m, `
+		SELECT calendar_id, provider_id, src_calendar_id, src_provider_id, parent_id, starts_at, ends_at
+		FROM events
+		WHERE calendar_id = ? AND provider_id = ?
+	`, cal.ID, eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.Convert(), nil
+}
+
+func (s Storage) CreateEvent(ctx context.Context, dst, src *internal.Calendar, dstEventID, srcEventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO events (calendar_id, provider_id, src_calendar_id, src_provider_id)
+		VALUES (?, ?, NULLIF(?, ""), ?)
+	`, dst.ID, dstEventID, src.ID, srcEventID)
+	return err
+}
+
+// SaveEvent creates or updates the mapping.
+func (s Storage) SaveEvent(ctx context.Context, m *internal.EventMapping) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO events (calendar_id, provider_id, src_calendar_id, src_provider_id, parent_id, starts_at, ends_at)
+		VALUES (?, ?, NULLIF(?, ""), ?, ?, ?, ?)
+		ON CONFLICT(calendar_id, provider_id) DO UPDATE
+			SET src_calendar_id = excluded.src_calendar_id,
+				src_provider_id = excluded.src_provider_id,
+				parent_id = excluded.parent_id,
+				starts_at = excluded.starts_at,
+				ends_at = excluded.ends_at;
+	`, m.CalendarID, m.EventID, m.SrcCalendarID, m.SrcEventID, m.ParentID, nullTime(m.StartsAt), nullTime(m.EndsAt))
+	return err
+}
+
+// ChildEvents returns the events created around the event in cal.
+func (s Storage) ChildEvents(ctx context.Context, cal *internal.Calendar, eventID string) ([]*internal.EventMapping, error) {
+	var mappings []EventMapping
+
+	err := s.conn(ctx).SelectContext(ctx, &mappings, `
+		SELECT calendar_id, provider_id, src_calendar_id, src_provider_id, parent_id, starts_at, ends_at
+		FROM events
+		WHERE calendar_id = ? AND parent_id = ?
+	`, cal.ID, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.EventMapping, len(mappings))
+	for i, m := range mappings {
+		res[i] = m.Convert()
+	}
+	return res, nil
+}
+
+// Overlaps checks if any event mapped in cal, except the ones in
+// exclude, overlaps with the interval.
+func (s Storage) Overlaps(ctx context.Context, cal *internal.Calendar, startsAt, endsAt time.Time, exclude ...string) (bool, error) {
+	query, args, err := sqlx.In(`
+		SELECT COUNT(*)
+		FROM events
+		WHERE calendar_id = ? AND starts_at < ? AND ends_at > ?
+			AND provider_id NOT IN (?)
+	`, cal.ID, endsAt.UTC(), startsAt.UTC(), append(exclude, ""))
+	if err != nil {
+		return false, err
+	}
+
+	var n int
+	err = s.conn(ctx).GetContext(ctx, &n, s.db.Rebind(query), args...)
+	return n > 0, err
+}
+
+// SaveMirror saves the event as it was written in cal, the event must be
+// mapped already.
+func (s Storage) SaveMirror(ctx context.Context, cal *internal.Calendar, event *internal.Event) error {
+	data, err := nullJSON(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `
+		UPDATE events SET event = ? WHERE calendar_id = ? AND provider_id = ?
+	`, data, cal.ID, event.ID)
+	return err
+}
+
+// Mirror returns the event as it was last written in cal, nil is returned
+// if it wasn't saved.
+func (s Storage) Mirror(ctx context.Context, cal *internal.Calendar, eventID string) (*internal.Event, error) {
+	var data sql.NullString
+	err := s.conn(ctx).GetContext(ctx, &data, `
+		SELECT event FROM events WHERE calendar_id = ? AND provider_id = ?
+	`, cal.ID, eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil || !data.Valid {
+		return nil, err
+	}
+	var event internal.Event
+	if err := json.Unmarshal([]byte(data.String), &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+func (s Storage) DeleteEvent(ctx context.Context, cal *internal.Calendar, eventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		DELETE FROM events WHERE calendar_id = ? AND provider_id = ?
+	`, cal.ID, eventID)
+	return err
+}
+
+func (s Storage) SaveLastSync(ctx context.Context, link *internal.Link, lastSync string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE links SET last_sync = ? WHERE id = ?
+	`, lastSync, link.ID)
+	return err
+}
+
+func (s Storage) SaveRetry(ctx context.Context, r *internal.Retry) error {
+	event, err := json.Marshal(r.Event)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO retries (calendar_id, src_calendar_id, src_provider_id, operation, event, attempts, last_error, next_attempt_at, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(calendar_id, src_calendar_id, src_provider_id) DO UPDATE
+			SET operation = excluded.operation,
+				event = excluded.event,
+				attempts = excluded.attempts,
+				last_error = excluded.last_error,
+				next_attempt_at = excluded.next_attempt_at,
+				status = excluded.status;
+	`, r.CalendarID, r.SrcCalendarID, r.Event.ID, r.Operation, string(event), r.Attempts, r.LastError, r.NextAttemptAt.UTC(), r.Status)
+	return err
+}
+
+// Retry returns the retry of the source event, nil is returned if there's
+// none.
+func (s Storage) Retry(ctx context.Context, dst, src *internal.Calendar, srcEventID string) (*internal.Retry, error) {
+	var r Retry
+	err := s.conn(ctx).GetContext(ctx, &r, `
+		SELECT * FROM retries
+		WHERE calendar_id = ? AND src_calendar_id = ? AND src_provider_id = ?
+	`, dst.ID, src.ID, srcEventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Convert()
+}
+
+// DueRetries returns the pending retries from src to dst that should be
+// attempted at now.
+func (s Storage) DueRetries(ctx context.Context, dst, src *internal.Calendar, now time.Time) ([]*internal.Retry, error) {
+	return s.retries(ctx, `
+		SELECT * FROM retries
+		WHERE calendar_id = ? AND src_calendar_id = ? AND status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at
+	`, dst.ID, src.ID, internal.RetryPending, now.UTC())
+}
+
+// Retries returns all retries, if status is not empty only the ones with
+// that status are returned.
+func (s Storage) Retries(ctx context.Context, status internal.RetryStatus) ([]*internal.Retry, error) {
+	return s.retries(ctx, `
+		SELECT * FROM retries
+		WHERE ? = "" OR status = ?
+		ORDER BY calendar_id, next_attempt_at
+	`, status, status)
+}
+
+func (s Storage) retries(ctx context.Context, query string, args ...interface{}) ([]*internal.Retry, error) {
+	var retries []Retry
+
+	err := s.conn(ctx).SelectContext(ctx, &retries, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.Retry, len(retries))
+	for i, r := range retries {
+		res[i], err = r.Convert()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (s Storage) DeleteRetry(ctx context.Context, dst, src *internal.Calendar, srcEventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		DELETE FROM retries
+		WHERE calendar_id = ? AND src_calendar_id = ? AND src_provider_id = ?
+	`, dst.ID, src.ID, srcEventID)
+	return err
+}
+
+// EventSourceID returns the id of the event in dst that the source event
+// contributes to.
+func (s Storage) EventSourceID(ctx context.Context, dst, src *internal.Calendar, srcEventID string) (string, error) {
+	var providerID string
+	err := s.conn(ctx).GetContext(ctx, &providerID, `
+		SELECT provider_id
+		FROM event_sources
+		WHERE calendar_id = ? AND src_calendar_id = ? AND src_provider_id = ?
+	`, dst.ID, src.ID, srcEventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return providerID, err
+}
+
+// DedupEventID returns the id of the event in dst that was created for
+// the dedup key.
+func (s Storage) DedupEventID(ctx context.Context, dst *internal.Calendar, key string) (string, error) {
+	var providerID string
+	err := s.conn(ctx).GetContext(ctx, &providerID, `
+		SELECT provider_id
+		FROM event_sources
+		WHERE calendar_id = ? AND dedup_key = ?
+		LIMIT 1
+	`, dst.ID, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return providerID, err
+}
+
+// EventSources returns the source events still active that contribute
+// to the event in dst.
+func (s Storage) EventSources(ctx context.Context, dst *internal.Calendar, dstEventID string) ([]*internal.EventMapping, error) {
+	var mappings []EventMapping
+
+	err := s.conn(ctx).SelectContext(ctx, &mappings, `
+		SELECT calendar_id, provider_id, src_calendar_id, src_provider_id
+		FROM event_sources
+		WHERE calendar_id = ? AND provider_id = ? AND active
+	`, dst.ID, dstEventID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.EventMapping, len(mappings))
+	for i, m := range mappings {
+		res[i] = m.Convert()
+	}
+	return res, nil
+}
+
+func (s Storage) SaveEventSource(ctx context.Context, m *internal.EventMapping, key string, active bool) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO event_sources (calendar_id, provider_id, src_calendar_id, src_provider_id, dedup_key, active)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(calendar_id, src_calendar_id, src_provider_id) DO UPDATE
+			SET provider_id = excluded.provider_id,
+				dedup_key = CASE WHEN excluded.dedup_key = "" THEN dedup_key ELSE excluded.dedup_key END,
+				active = excluded.active;
+	`, m.CalendarID, m.EventID, m.SrcCalendarID, m.SrcEventID, key, active)
+	return err
+}
+
+func (s Storage) DeleteEventSources(ctx context.Context, dst *internal.Calendar, dstEventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		DELETE FROM event_sources WHERE calendar_id = ? AND provider_id = ?
+	`, dst.ID, dstEventID)
+	return err
+}
+
+// ReassignEvent changes the source event that the event in dst is
+// mapped to.
+func (s Storage) ReassignEvent(ctx context.Context, m *internal.EventMapping) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE events SET src_calendar_id = NULLIF(?, ""), src_provider_id = ?
+		WHERE calendar_id = ? AND provider_id = ?
+	`, m.SrcCalendarID, m.SrcEventID, m.CalendarID, m.EventID)
+	return err
+}
+
+// BusySources returns the source events keeping dst busy, when src is nil
+// the events of all sources are returned.
+func (s Storage) BusySources(ctx context.Context, dst, src *internal.Calendar) ([]*internal.EventMapping, error) {
+	where := "calendar_id = ?"
+	args := []interface{}{dst.ID}
+	if src != nil {
+		where += " AND src_calendar_id = ?"
+		args = append(args, src.ID)
+	}
+
+	var mappings []EventMapping
+	err := s.conn(ctx).SelectContext(ctx, &mappings, `
+		SELECT calendar_id, src_calendar_id, src_provider_id, starts_at, ends_at
+		FROM busy_sources
+		WHERE `+where+`
+		ORDER BY starts_at
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.EventMapping, len(mappings))
+	for i, m := range mappings {
+		res[i] = m.Convert()
+	}
+	return res, nil
+}
+
+func (s Storage) SaveBusySource(ctx context.Context, m *internal.EventMapping) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO busy_sources (calendar_id, src_calendar_id, src_provider_id, starts_at, ends_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(calendar_id, src_calendar_id, src_provider_id) DO UPDATE
+			SET starts_at = excluded.starts_at,
+				ends_at = excluded.ends_at;
+	`, m.CalendarID, m.SrcCalendarID, m.SrcEventID, m.StartsAt.UTC(), m.EndsAt.UTC())
+	return err
+}
+
+func (s Storage) DeleteBusySource(ctx context.Context, dst, src *internal.Calendar, srcEventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		DELETE FROM busy_sources
+		WHERE calendar_id = ? AND src_calendar_id = ? AND src_provider_id = ?
+	`, dst.ID, src.ID, srcEventID)
+	return err
+}
+
+// BusyBlocks returns the blocks created in dst ordered by when they start.
+func (s Storage) BusyBlocks(ctx context.Context, dst *internal.Calendar) ([]*internal.BusyBlock, error) {
+	var blocks []BusyBlock
+
+	err := s.conn(ctx).SelectContext(ctx, &blocks, `
+		SELECT calendar_id, provider_id, starts_at, ends_at
+		FROM busy_blocks
+		WHERE calendar_id = ?
+		ORDER BY starts_at
+	`, dst.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.BusyBlock, len(blocks))
+	for i, b := range blocks {
+		res[i] = b.Convert()
+	}
+	return res, nil
+}
+
+func (s Storage) SaveBusyBlock(ctx context.Context, b *internal.BusyBlock) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO busy_blocks (calendar_id, provider_id, starts_at, ends_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(calendar_id, provider_id) DO UPDATE
+			SET starts_at = excluded.starts_at,
+				ends_at = excluded.ends_at;
+	`, b.CalendarID, b.EventID, b.StartsAt.UTC(), b.EndsAt.UTC())
+	return err
+}
+
+func (s Storage) DeleteBusyBlock(ctx context.Context, dst *internal.Calendar, eventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		DELETE FROM busy_blocks WHERE calendar_id = ? AND provider_id = ?
+	`, dst.ID, eventID)
+	return err
+}
+
+// MirrorOrigin returns where the event in cal was mirrored from, nil is
+// returned if the event wasn't created by us.
+func (s Storage) MirrorOrigin(ctx context.Context, cal *internal.Calendar, eventID string) (*internal.Origin, error) {
+	var m EventMapping
+	err := s.conn(ctx).GetContext(ctx, &m, `
+		SELECT e.calendar_id, e.provider_id, e.src_calendar_id, e.src_provider_id
+		FROM events e
+		INNER JOIN calendars c ON c.account_id || "/" || c.name = e.calendar_id
+		WHERE e.provider_id = ? AND c.account_id = ? AND c.provider_id = ?
+		LIMIT 1
+	`, eventID, cal.Account.ID(), cal.ProviderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &internal.Origin{
+		CalendarID: m.SrcCalendarID.String,
+		EventID:    m.SrcProviderID,
+	}, nil
+}
+
+// StartRun saves the start of the run, setting its id.
+func (s Storage) StartRun(ctx context.Context, run *internal.Run) error {
+	args, err := json.Marshal(run.Args)
+	if err != nil {
+		return err
+	}
+	return s.conn(ctx).GetContext(ctx, &run.ID, `
+		INSERT INTO runs (started_at, args) VALUES (?, ?)
+		RETURNING id
+	`, run.StartedAt.UTC(), string(args))
+}
+
+// FinishRun saves how the run ended and what happened with its links.
+func (s Storage) FinishRun(ctx context.Context, run *internal.Run) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE runs SET ended_at = ?, error = ? WHERE id = ?
+	`, run.EndedAt.UTC(), run.Error, run.ID)
+	if err != nil {
+		return err
+	}
+	for _, l := range run.Links {
+		errs, err := json.Marshal(l.Errors)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO run_links (run_id, link_id, source, destination, created, updated, deleted, skipped, failed, vetoed, duration, token_advanced, errors)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, run.ID, l.LinkID, l.Source, l.Destination, l.Created, l.Updated, l.Deleted, l.Skipped, l.Failed, l.Vetoed, int64(l.Duration), l.TokenAdvanced, string(errs))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Runs returns the last runs, most recent first.
+func (s Storage) Runs(ctx context.Context, limit int) ([]*internal.Run, error) {
+	var runs []Run
+	err := s.conn(ctx).SelectContext(ctx, &runs, `
+		SELECT id, started_at, ended_at, args, error
+		FROM runs
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.Run, len(runs))
+	for i, r := range runs {
+		res[i], err = s.run(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Run returns the run with the id, nil is returned if there's none.
+func (s Storage) Run(ctx context.Context, id int64) (*internal.Run, error) {
+	var r Run
+	err := s.conn(ctx).GetContext(ctx, &r, `
+		SELECT id, started_at, ended_at, args, error
+		FROM runs
+		WHERE id = ?
+	`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.run(ctx, r)
+}
+
+// run converts r loading its links.
+func (s Storage) run(ctx context.Context, r Run) (*internal.Run, error) {
+	run, err := r.Convert()
+	if err != nil {
+		return nil, err
+	}
+
+	var links []RunLink
+	err = s.conn(ctx).SelectContext(ctx, &links, `
+		SELECT * FROM run_links WHERE run_id = ? ORDER BY link_id
+	`, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	run.Links = make([]*internal.RunLink, len(links))
+	for i, l := range links {
+		run.Links[i], err = l.Convert()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+// DeleteRunsBefore deletes the runs started before t, it returns how many
+// were deleted.
+func (s Storage) DeleteRunsBefore(ctx context.Context, t time.Time) (int64, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM run_links WHERE run_id IN (SELECT id FROM runs WHERE started_at < ?)
+	`, t.UTC())
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM audit WHERE run_id IN (SELECT id FROM runs WHERE started_at < ?)
+	`, t.UTC())
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM runs WHERE started_at < ?`, t.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// SaveAudit saves the entry, when its link isn't set it's taken from the
+// source and destination calendars.
+func (s Storage) SaveAudit(ctx context.Context, e *internal.AuditEntry) error {
+	before, err := nullJSON(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := nullJSON(e.After)
+	if err != nil {
+		return err
+	}
+	return s.conn(ctx).GetContext(ctx, &e.ID, `
+		INSERT INTO audit (run_id, link_id, calendar_id, provider_id, kind, operation, src_calendar_id, src_provider_id, parent_id, before, after, created_at)
+		VALUES (?, COALESCE(NULLIF(?, 0), (SELECT id FROM links WHERE src_calendar_id = ? AND dst_calendar_id = ?), 0), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, e.RunID, e.LinkID, e.SrcCalendarID, e.CalendarID, e.CalendarID, e.EventID, e.Kind, e.Operation,
+		e.SrcCalendarID, e.SrcEventID, e.ParentID, before, after, e.CreatedAt.UTC())
+}
+
+func nullJSON(v *internal.Event) (sql.NullString, error) {
+	if v == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(v)
+	return sql.NullString{String: string(b), Valid: err == nil}, err
+}
+
+// AuditEntries returns what was done during the run in the order it was
+// done.
+func (s Storage) AuditEntries(ctx context.Context, runID int64) ([]*internal.AuditEntry, error) {
+	var entries []AuditEntry
+	err := s.conn(ctx).SelectContext(ctx, &entries, `
+		SELECT * FROM audit WHERE run_id = ? ORDER BY id
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*internal.AuditEntry, len(entries))
+	for i, e := range entries {
+		res[i], err = e.Convert()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// SetAuditUndone marks the entry as undone.
+func (s Storage) SetAuditUndone(ctx context.Context, e *internal.AuditEntry) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE audit SET undone_at = ? WHERE id = ?
+	`, e.UndoneAt.UTC(), e.ID)
+	return err
+}
+
+// SaveSourceEvent saves the latest state of the event of the source
+// calendar, cancelled events are removed.
+func (s Storage) SaveSourceEvent(ctx context.Context, cal *internal.Calendar, event *internal.Event) error {
+	if event.ResponseStatus == internal.Cancelled {
+		return s.DeleteSourceEvent(ctx, cal, event.ID)
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `
+		INSERT INTO source_events (calendar_id, provider_id, summary, description, starts_at, ends_at, event, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(calendar_id, provider_id) DO UPDATE
+			SET summary = excluded.summary,
+				description = excluded.description,
+				starts_at = excluded.starts_at,
+				ends_at = excluded.ends_at,
+				event = excluded.event,
+				updated_at = excluded.updated_at;
+	`, cal.ID, event.ID, event.Summary, event.Description, nullTime(event.StartsAt), nullTime(event.EndsAt), string(data), time.Now().UTC())
+	return err
+}
+
+func (s Storage) DeleteSourceEvent(ctx context.Context, cal *internal.Calendar, eventID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		DELETE FROM source_events WHERE calendar_id = ? AND provider_id = ?
+	`, cal.ID, eventID)
+	return err
+}
+
+// DeleteSourceEventsBefore deletes the events of cal saved before t, it's
+// used after listing all events to remove the ones that don't exist
+// anymore.
+func (s Storage) DeleteSourceEventsBefore(ctx context.Context, cal *internal.Calendar, t time.Time) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `
+		DELETE FROM source_events WHERE calendar_id = ? AND updated_at < ?
+	`, cal.ID, t.UTC())
+	return err
+}
+
+// SourceEvent returns the latest state saved of the event, nil is
+// returned if there's none.
+func (s Storage) SourceEvent(ctx context.Context, cal *internal.Calendar, eventID string) (*internal.SourceEvent, error) {
+	var e SourceEvent
+	err := s.conn(ctx).GetContext(ctx, &e, `
+		SELECT calendar_id, event, updated_at
+		FROM source_events
+		WHERE calendar_id = ? AND provider_id = ?
+	`, cal.ID, eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.Convert()
+}
+
+// SourceEvents returns the events of the source calendars matching q,
+// ordered by when they start.
+func (s Storage) SourceEvents(ctx context.Context, q internal.EventQuery) ([]*internal.SourceEvent, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if len(q.CalendarIDs) > 0 {
+		where = append(where, "calendar_id IN (?)")
+		args = append(args, q.CalendarIDs)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "ends_at > ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "starts_at < ?")
+		args = append(args, q.To.UTC())
+	}
+	if q.Text != "" {
+		where = append(where, "(summary LIKE ? OR description LIKE ?)")
+		text := "%" + q.Text + "%"
+		args = append(args, text, text)
+	}
+	limit := -1
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+
+	query, args, err := sqlx.In(`
+		SELECT calendar_id, event, updated_at
+		FROM source_events
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY starts_at, calendar_id, provider_id
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []SourceEvent
+	err = s.conn(ctx).SelectContext(ctx, &events, s.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*internal.SourceEvent, len(events))
+	for i, e := range events {
+		res[i], err = e.Convert()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
 }
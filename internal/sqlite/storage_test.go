@@ -0,0 +1,389 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// newTestDB returns a new empty database.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestStorage returns a migrated storage on a new database, with the
+// accounts of alice and bob.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s := NewStorage(newTestDB(t), nil)
+	if err := s.Migrate(context.Background(), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range []internal.Account{alice, bob} {
+		if err := s.AddAccount(context.Background(), &acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+var (
+	alice = internal.Account{Platform: "google", Name: "alice@example.com"}
+	bob   = internal.Account{Platform: "google", Name: "bob@example.com"}
+)
+
+func testCalendar(acc internal.Account, name, providerID string) *internal.Calendar {
+	return &internal.Calendar{
+		ID:         acc.ID() + "/" + name,
+		Name:       name,
+		ProviderID: providerID,
+		Account:    acc,
+	}
+}
+
+// saveCalendar saves the calendar without linking it.
+func saveCalendar(t *testing.T, s *Storage, cal *internal.Calendar) {
+	t.Helper()
+	_, err := s.db.Exec(`
+		INSERT INTO calendars (account_id, name, provider_id) VALUES (?, ?, ?)
+	`, cal.Account.ID(), cal.Name, cal.ProviderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreatesCycle(t *testing.T) {
+	var (
+		work     = testCalendar(alice, "work", "work@group.calendar.google.com")
+		personal = testCalendar(alice, "personal", "primary")
+		family   = testCalendar(bob, "family", "family@group.calendar.google.com")
+		shared   = testCalendar(bob, "shared", "work@group.calendar.google.com")
+		alices   = testCalendar(bob, "alice", "alice@example.com")
+	)
+	type link struct{ src, dst *internal.Calendar }
+
+	tests := []struct {
+		name  string
+		links []link
+		link  link
+		want  bool
+	}{
+		{"no links", nil, link{work, personal}, false},
+		{"same link again", []link{{work, personal}}, link{work, personal}, false},
+		{"other destination", []link{{work, personal}}, link{work, family}, false},
+		{"reverse link", []link{{work, personal}}, link{personal, work}, true},
+		{"through another calendar", []link{{work, personal}, {personal, family}}, link{family, work}, true},
+		{"same calendar on the provider", []link{{work, personal}}, link{personal, shared}, true},
+		{"primary calendar", []link{{family, personal}}, link{alices, family}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStorage(t)
+			for _, l := range tt.links {
+				err := s.LinkCalendar(ctx, &internal.Link{Source: l.src, Destination: l.dst})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tx, err := s.db.BeginTxx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			got, err := createsCycle(ctx, tx, &internal.Link{Source: tt.link.src, Destination: tt.link.dst})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("createsCycle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameCalendar(t *testing.T) {
+	var (
+		work     = testCalendar(alice, "work", "work@group.calendar.google.com")
+		personal = testCalendar(alice, "personal", "primary")
+		office   = testCalendar(alice, "office", work.ProviderID)
+		home     = testCalendar(alice, "home", personal.ProviderID)
+	)
+	tests := []struct {
+		name     string
+		cal      *internal.Calendar
+		renamed  *internal.Calendar
+		src, dst *internal.Calendar
+	}{
+		{"source", work, office, office, personal},
+		{"destination", personal, home, work, home},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStorage(t)
+			if err := s.LinkCalendar(ctx, &internal.Link{Source: work, Destination: personal}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.CreateEvent(ctx, personal, work, "m1", "e1"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.RenameCalendar(ctx, tt.cal, tt.renamed.Name); err != nil {
+				t.Fatal(err)
+			}
+
+			if cal, err := s.Calendar(ctx, tt.cal.ID); err != nil || cal != nil {
+				t.Errorf("Calendar(%s) = %v, %v, want nil", tt.cal.ID, cal, err)
+			}
+			links, err := s.Links(ctx, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(links) != 1 || links[0].Source.ID != tt.src.ID {
+				t.Fatalf("Links() = %v, want the link from %s", links, tt.src)
+			}
+			id, err := s.DestinationEventID(ctx, tt.dst, tt.src, "e1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "m1" {
+				t.Errorf("DestinationEventID() = %q, want %q", id, "m1")
+			}
+		})
+	}
+}
+
+func TestDeleteLink(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	var (
+		work     = testCalendar(alice, "work", "work@group.calendar.google.com")
+		personal = testCalendar(alice, "personal", "primary")
+		family   = testCalendar(bob, "family", "family@group.calendar.google.com")
+		// unlinked isn't used by any link.
+		unlinked = testCalendar(bob, "unlinked", "unlinked@group.calendar.google.com")
+	)
+	saveCalendar(t, s, unlinked)
+	link := &internal.Link{Source: work, Destination: personal}
+	for _, l := range []*internal.Link{link, {Source: family, Destination: personal}} {
+		if err := s.LinkCalendar(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.CreateEvent(ctx, personal, work, "m1", "e1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateEvent(ctx, personal, family, "m2", "e2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteLink(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		cal  *internal.Calendar
+		kept bool
+	}{
+		{work, false},
+		{personal, true},
+		{family, true},
+		{unlinked, true},
+	} {
+		cal, err := s.Calendar(ctx, tt.cal.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept := cal != nil; kept != tt.kept {
+			t.Errorf("calendar %s kept = %v, want %v", tt.cal.ID, kept, tt.kept)
+		}
+	}
+	mappings, err := s.EventMappings(ctx, personal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 1 || mappings[0].SrcCalendarID != family.ID {
+		t.Errorf("EventMappings() = %v, want only the event from %s", mappings, family)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	var (
+		work     = testCalendar(alice, "work", "work@group.calendar.google.com")
+		personal = testCalendar(alice, "personal", "primary")
+		family   = testCalendar(bob, "family", "family@group.calendar.google.com")
+		home     = testCalendar(bob, "home", "primary")
+		shared   = testCalendar(bob, "shared", "shared@group.calendar.google.com")
+		unlinked = testCalendar(bob, "unlinked", "unlinked@group.calendar.google.com")
+	)
+	saveCalendar(t, s, unlinked)
+	for _, l := range []*internal.Link{
+		{Source: work, Destination: family},
+		{Source: home, Destination: family},
+		{Source: personal, Destination: shared},
+	} {
+		if err := s.LinkCalendar(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.CreateEvent(ctx, family, work, "m1", "e1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateEvent(ctx, family, home, "m2", "e2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteAccount(ctx, &alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if acc, err := s.Account(ctx, alice.ID()); err != nil || acc != nil {
+		t.Errorf("Account(%s) = %v, %v, want nil", alice.ID(), acc, err)
+	}
+	for _, tt := range []struct {
+		cal  *internal.Calendar
+		kept bool
+	}{
+		{work, false},
+		{personal, false},
+		{shared, false},
+		{family, true},
+		{home, true},
+		{unlinked, true},
+	} {
+		cal, err := s.Calendar(ctx, tt.cal.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept := cal != nil; kept != tt.kept {
+			t.Errorf("calendar %s kept = %v, want %v", tt.cal.ID, kept, tt.kept)
+		}
+	}
+	mappings, err := s.EventMappings(ctx, family, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 1 || mappings[0].SrcCalendarID != home.ID {
+		t.Errorf("EventMappings() = %v, want only the event from %s", mappings, home)
+	}
+}
+
+func TestSaveMirror(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	work := testCalendar(alice, "work", "work@group.calendar.google.com")
+	personal := testCalendar(alice, "personal", "primary")
+	if err := s.LinkCalendar(ctx, &internal.Link{Source: work, Destination: personal}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateEvent(ctx, personal, work, "e1", "s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Mirror(ctx, personal, "e1")
+	if err != nil || got != nil {
+		t.Fatalf("Mirror() = %+v, %v, want nil before saving it", got, err)
+	}
+	want := &internal.Event{
+		ID:      "e1",
+		Summary: "[personal] Standup",
+		Origin:  &internal.Origin{CalendarID: work.ID, EventID: "s1"},
+	}
+	if err := s.SaveMirror(ctx, personal, want); err != nil {
+		t.Fatal(err)
+	}
+	// Saving the mapping keeps the mirror.
+	if err := s.SaveEvent(ctx, &internal.EventMapping{CalendarID: personal.ID, EventID: "e1", SrcCalendarID: work.ID, SrcEventID: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Mirror(ctx, personal, "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Summary != want.Summary || *got.Origin != *want.Origin {
+		t.Errorf("Mirror() = %+v, want %+v", got, want)
+	}
+
+	if err := s.DeleteEvent(ctx, personal, "e1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Mirror(ctx, personal, "e1"); err != nil || got != nil {
+		t.Errorf("Mirror() = %+v, %v, want nil after deleting the event", got, err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	work := testCalendar(alice, "work", "work@group.calendar.google.com")
+	personal := testCalendar(alice, "personal", "primary")
+	if err := s.LinkCalendar(ctx, &internal.Link{Source: work, Destination: personal}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateEvent(ctx, personal, work, "e1", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	startsAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	m := &internal.EventMapping{
+		CalendarID:    personal.ID,
+		EventID:       "e1",
+		SrcCalendarID: work.ID,
+		SrcEventID:    "s1",
+		StartsAt:      startsAt,
+		EndsAt:        startsAt.Add(time.Hour),
+	}
+	if err := s.SaveEventSource(ctx, m, "key", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveBusySource(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	block := &internal.BusyBlock{CalendarID: personal.ID, EventID: "b1", StartsAt: m.StartsAt, EndsAt: m.EndsAt}
+	if err := s.SaveBusyBlock(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := s.Export(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.EventSources) != 1 || len(want.BusySources) != 1 || len(want.BusyBlocks) != 1 {
+		t.Fatalf("got %d event source(s), %d busy source(s) and %d busy block(s), want 1 of each",
+			len(want.EventSources), len(want.BusySources), len(want.BusyBlocks))
+	}
+
+	imported := newTestStorage(t)
+	if err := imported.Import(ctx, want, internal.ImportReplace, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := imported.Export(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.ExportedAt = want.ExportedAt
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		t.Errorf("Export() after Import() = %s, want %s", gotJSON, wantJSON)
+	}
+}
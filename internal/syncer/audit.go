@@ -0,0 +1,290 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// previousMirror returns the event as it was last written in cal, it's
+// read from cal when it wasn't saved. Nil is returned if it doesn't exist
+// or couldn't be read.
+func (s Syncer) previousMirror(ctx context.Context, provider internal.Provider, cal *Calendar, eventID string) *Event {
+	if eventID == "" {
+		return nil
+	}
+	event, err := s.storage.Mirror(ctx, cal, eventID)
+	if err == nil && event == nil {
+		event, err = provider.Event(ctx, cal, eventID)
+	}
+	if err != nil {
+		logf(s.output, cal, "Unable to get event %s before changing it: %v", eventID, err)
+		return nil
+	}
+	return event
+}
+
+// mapping returns the mapping of the event, nil is returned if it isn't
+// mapped or couldn't be read.
+func (s Syncer) mapping(ctx context.Context, cal *Calendar, eventID string) *internal.EventMapping {
+	m, err := s.storage.EventMapping(ctx, cal, eventID)
+	if err != nil {
+		logf(s.output, cal, "Unable to get mapping of event %s: %v", eventID, err)
+		return nil
+	}
+	return m
+}
+
+// audit saves the operation done in the destination calendar, errors are
+// only logged as the operation was already done.
+func (s Syncer) audit(ctx context.Context, e *internal.AuditEntry, m *internal.EventMapping) {
+	e.RunID = s.RunID
+	e.CreatedAt = time.Now()
+	if m != nil {
+		e.SrcCalendarID = m.SrcCalendarID
+		e.SrcEventID = m.SrcEventID
+		e.ParentID = m.ParentID
+	}
+	// The operation must be saved even when the sync was interrupted.
+	err := s.storage.SaveAudit(context.WithoutCancel(ctx), e)
+	if err != nil {
+		logf(s.output, nil, "Unable to save audit of event %s: %v", e.EventID, err)
+	}
+}
+
+// UndoReport holds what was undone of a run.
+type UndoReport struct {
+	RunID    int64
+	Deleted  int
+	Restored int
+	Reverted int
+	Skipped  int
+	Failed   int
+}
+
+// Undo restores the destination calendars to their state before the run,
+// events created are deleted, updated ones are reverted and deleted ones
+// are created again. Operations are undone from the last to the first and
+// the mappings are fixed to match the events.
+func (s Syncer) Undo(ctx context.Context, runID int64, dryRun bool) (*UndoReport, error) {
+	entries, err := s.storage.AuditEntries(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UndoReport{RunID: runID}
+	cals := make(map[string]*Calendar)
+	// Events created again get a new id.
+	ids := make(map[string]string)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		e := entries[i]
+		if !e.UndoneAt.IsZero() {
+			report.Skipped++
+			continue
+		}
+
+		cal, ok := cals[e.CalendarID]
+		if !ok {
+			dstcals, err := s.storage.DestinationCalendars(ctx, []string{e.CalendarID})
+			if err != nil {
+				return report, err
+			}
+			if len(dstcals) > 0 {
+				cal = dstcals[0]
+			}
+			cals[e.CalendarID] = cal
+		}
+		if cal == nil {
+			logf(s.output, nil, "Skipping %s of event %s, calendar %s isn't a destination anymore", e.Operation, e.EventID, e.CalendarID)
+			report.Skipped++
+			continue
+		}
+		if newID, ok := ids[e.EventID]; ok {
+			e.EventID = newID
+		}
+		if newID, ok := ids[e.ParentID]; ok {
+			e.ParentID = newID
+		}
+		if dryRun {
+			logf(s.output, cal, "Would undo %s of event %s", e.Operation, e.EventID)
+			continue
+		}
+
+		err := s.undoEntry(ctx, cal, e, ids)
+		if errors.Is(err, errNothingToUndo) {
+			report.Skipped++
+			continue
+		}
+		if err != nil {
+			report.Failed++
+			continue
+		}
+		switch e.Operation {
+		case internal.OperationCreate:
+			report.Deleted++
+		case internal.OperationUpdate:
+			report.Reverted++
+		case internal.OperationDelete:
+			report.Restored++
+		}
+
+		e.UndoneAt = time.Now()
+		if err := s.storage.SetAuditUndone(ctx, e); err != nil {
+			logf(s.output, cal, "Unable to save undo of event %s: %v", e.EventID, err)
+		}
+	}
+	return report, nil
+}
+
+var errNothingToUndo = errors.New("nothing to undo")
+
+// undoEntry reverts the operation on cal, the ids of the events created
+// again are added to ids.
+func (s Syncer) undoEntry(ctx context.Context, cal *Calendar, e *internal.AuditEntry, ids map[string]string) error {
+	provider, err := s.mux.Get(cal.Account.Platform)
+	if err != nil {
+		logf(s.output, cal, "Unable to load provider: %v", err)
+		return err
+	}
+
+	switch e.Operation {
+	case internal.OperationCreate:
+		logf(s.output, cal, "Undoing create of event %s", e.EventID)
+
+		err := provider.DeleteEvent(ctx, cal, e.EventID)
+		if err != nil {
+			logf(s.output, cal, "Unable to delete event from provider %s: %v", e.EventID, err)
+			return err
+		}
+		if e.Kind == internal.AuditBusy {
+			err = s.storage.DeleteBusyBlock(ctx, cal, e.EventID)
+		} else {
+			err = s.storage.DeleteEvent(ctx, cal, e.EventID)
+			if err == nil {
+				err = s.storage.DeleteEventSources(ctx, cal, e.EventID)
+			}
+		}
+		if err != nil {
+			logf(s.output, cal, "Unable to delete event from storage %s: %v", e.EventID, err)
+		}
+		return err
+
+	case internal.OperationUpdate:
+		if e.Before == nil {
+			logf(s.output, cal, "Unable to undo update of event %s, it wasn't saved before the update", e.EventID)
+			return errNothingToUndo
+		}
+		logf(s.output, cal, "Undoing update of event %s: %q on %s", e.EventID, e.Before.Summary, formatDateTime(e.Before.StartsAt))
+
+		event := *e.Before
+		event.ID = e.EventID
+		err := provider.UpdateEvent(ctx, cal, &event)
+		if err != nil {
+			logf(s.output, cal, "Unable to update event on the provider %s: %v", e.EventID, err)
+			return err
+		}
+		return s.saveUndoMapping(ctx, cal, e, &event)
+
+	case internal.OperationDelete:
+		if e.Before == nil {
+			// It didn't exist when it was deleted.
+			return errNothingToUndo
+		}
+		logf(s.output, cal, "Undoing delete of event %s: %q on %s", e.EventID, e.Before.Summary, formatDateTime(e.Before.StartsAt))
+
+		newEvent, err := provider.CreateEvent(ctx, cal, e.Before)
+		if err != nil {
+			logf(s.output, cal, "Unable to create event on the provider: %v", err)
+			return err
+		}
+		ids[e.EventID] = newEvent.ID
+
+		// Buffers restored before their mirror point to its old id.
+		children, err := s.storage.ChildEvents(ctx, cal, e.EventID)
+		if err != nil {
+			logf(s.output, cal, "Unable to get buffers of event %s: %v", e.EventID, err)
+			return err
+		}
+		for _, m := range children {
+			m.ParentID = newEvent.ID
+			if err := s.storage.SaveEvent(ctx, m); err != nil {
+				logf(s.output, cal, "Unable to update event on the storage %s: %v", m.EventID, err)
+				return err
+			}
+		}
+		e.EventID = newEvent.ID
+		return s.saveUndoMapping(ctx, cal, e, e.Before)
+	}
+	return errNothingToUndo
+}
+
+// saveUndoMapping maps the event again as it was before the operation,
+// event is how it was restored in cal.
+func (s Syncer) saveUndoMapping(ctx context.Context, cal *Calendar, e *internal.AuditEntry, event *Event) error {
+	var err error
+	switch {
+	case e.Kind == internal.AuditBusy:
+		err = s.storage.SaveBusyBlock(ctx, &internal.BusyBlock{
+			CalendarID: cal.ID,
+			EventID:    e.EventID,
+			StartsAt:   event.StartsAt,
+			EndsAt:     event.EndsAt,
+		})
+	case e.SrcCalendarID != "":
+		m := &internal.EventMapping{
+			CalendarID:    cal.ID,
+			EventID:       e.EventID,
+			SrcCalendarID: e.SrcCalendarID,
+			SrcEventID:    e.SrcEventID,
+			ParentID:      e.ParentID,
+			StartsAt:      event.StartsAt,
+			EndsAt:        event.EndsAt,
+		}
+		err = s.storage.SaveEvent(ctx, m)
+		if err == nil {
+			restored := *event
+			restored.ID = e.EventID
+			err = s.storage.SaveMirror(ctx, cal, &restored)
+		}
+		if err == nil && cal.Mode == internal.CalendarModeDedup && e.ParentID == "" {
+			// Otherwise the source event would be mirrored again.
+			err = s.storage.SaveEventSource(ctx, m, s.undoDedupKey(ctx, cal, e), true)
+		}
+	}
+	if err != nil {
+		logf(s.output, cal, "Unable to update event on the storage %s: %v", e.EventID, err)
+	}
+	return err
+}
+
+// undoDedupKey returns the dedup key of the source event of the entry as
+// it's mirrored into cal, empty if the source event isn't saved anymore.
+func (s Syncer) undoDedupKey(ctx context.Context, cal *Calendar, e *internal.AuditEntry) string {
+	links, err := s.storage.Links(ctx, cal)
+	if err != nil {
+		logf(s.output, cal, "Unable to get source calendars: %v", err)
+		return ""
+	}
+	for _, link := range links {
+		if link.Source.ID != e.SrcCalendarID {
+			continue
+		}
+		cached, err := s.storage.SourceEvent(ctx, link.Source, e.SrcEventID)
+		if err != nil {
+			logf(s.output, cal, "Unable to get event %s from %s: %v", e.SrcEventID, link.Source, err)
+			return ""
+		}
+		if cached == nil {
+			return ""
+		}
+		event := *cached.Event
+		renderMirror(link, &internal.Origin{CalendarID: link.Source.ID, EventID: event.ID}, &event)
+		return dedupKey(&event)
+	}
+	return ""
+}
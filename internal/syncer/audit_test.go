@@ -0,0 +1,145 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// syncRun syncs all links saving the operations with runID.
+func syncRun(t *testing.T, s *Syncer, runID int64) {
+	t.Helper()
+	s.RunID = runID
+	report, err := s.Sync(context.Background(), nil, false, internal.Date{})
+	if err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if report.Failed() {
+		t.Fatalf("Sync() failed: %v", reportErrors(report))
+	}
+}
+
+func undoRun(t *testing.T, s *Syncer, runID int64) *UndoReport {
+	t.Helper()
+	report, err := s.Undo(context.Background(), runID, false)
+	if err != nil {
+		t.Fatalf("Undo() = %v", err)
+	}
+	if report.Failed > 0 {
+		t.Fatalf("Undo() failed %d operation(s)", report.Failed)
+	}
+	return report
+}
+
+func TestUndoCreate(t *testing.T) {
+	ctx := context.Background()
+	src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+	storage := newFakeStorage(&Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive})
+	provider := newFakeProvider()
+	provider.add(src, testEvent("s1", "Standup", 9))
+	s := newTestSyncer(storage, provider)
+	syncRun(t, s, 1)
+
+	report := undoRun(t, s, 1)
+	if report.Deleted != 1 {
+		t.Errorf("got %d deleted, want 1", report.Deleted)
+	}
+	if got := len(provider.list(dst)); got != 0 {
+		t.Errorf("got %d mirror(s), want 0", got)
+	}
+	if id, _ := storage.DestinationEventID(ctx, dst, src, "s1"); id != "" {
+		t.Errorf("mapping of s1 = %s, want it deleted", id)
+	}
+}
+
+func TestUndoUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		// saved keeps the mirror written in the storage, otherwise it's
+		// read from the destination.
+		saved bool
+	}{
+		{"saved mirror", true},
+		{"mirror not saved", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+			storage := newFakeStorage(&Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive})
+			provider := newFakeProvider()
+			provider.add(src, testEvent("s1", "Standup", 9))
+			s := newTestSyncer(storage, provider)
+			s.Hooks = fakeHooks{change: func(_ internal.Operation, dst *Event) {
+				dst.ColorID = "5"
+			}}
+			syncRun(t, s, 1)
+			want := provider.list(dst)[0]
+			if !tt.saved {
+				clear(storage.mirrors)
+			}
+
+			provider.add(src, testEvent("s1", "Standup", 10))
+			s.Hooks = nil
+			syncRun(t, s, 2)
+
+			report := undoRun(t, s, 2)
+			if report.Reverted != 1 {
+				t.Errorf("got %d reverted, want 1", report.Reverted)
+			}
+			got := provider.list(dst)
+			if len(got) != 1 || *got[0].Origin != *want.Origin {
+				t.Fatalf("got mirrors %+v, want %+v", got, want)
+			}
+			got[0].Origin = want.Origin
+			if *got[0] != *want {
+				t.Errorf("got mirror %+v, want %+v", got[0], want)
+			}
+		})
+	}
+}
+
+func TestUndoDelete(t *testing.T) {
+	ctx := context.Background()
+	src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+	dst.Mode = internal.CalendarModeDedup
+	storage := newFakeStorage(&Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive})
+	provider := newFakeProvider()
+	event := testEvent("s1", "Standup", 9)
+	event.ICalUID = "standup"
+	provider.add(src, event)
+	s := newTestSyncer(storage, provider)
+	syncRun(t, s, 1)
+	key := dedupKey(event)
+
+	declined := *event
+	declined.ResponseStatus = internal.Declined
+	provider.add(src, &declined)
+	syncRun(t, s, 2)
+	if got := len(provider.list(dst)); got != 0 {
+		t.Fatalf("got %d mirror(s), want the mirror deleted", got)
+	}
+
+	report := undoRun(t, s, 2)
+	if report.Restored != 1 {
+		t.Errorf("got %d restored, want 1", report.Restored)
+	}
+	mirrors := provider.list(dst)
+	if len(mirrors) != 1 {
+		t.Fatalf("got %d mirror(s), want 1", len(mirrors))
+	}
+	restored := mirrors[0].ID
+	if id, _ := storage.EventSourceID(ctx, dst, src, "s1"); id != restored {
+		t.Errorf("source of s1 = %q, want %q", id, restored)
+	}
+	if id, _ := storage.DedupEventID(ctx, dst, key); id != restored {
+		t.Errorf("event of dedup key = %q, want %q", id, restored)
+	}
+
+	// Accepting the event again updates the restored mirror.
+	provider.add(src, event)
+	syncRun(t, s, 3)
+	if mirrors := provider.list(dst); len(mirrors) != 1 || mirrors[0].ID != restored {
+		t.Errorf("got mirrors %+v, want only %s", mirrors, restored)
+	}
+}
@@ -0,0 +1,66 @@
+package syncer
+
+import "context"
+
+// batch holds the writes deferred while syncing a page of events, they
+// are saved in a single transaction at the end of the page, after calling
+// the providers. Only writes not read back while syncing the page can be
+// deferred, each source event is received once per page so its mappings
+// are only read on the next pages.
+type batch struct {
+	writes []func(context.Context) error
+	// undo reverts what was done on the providers when the writes
+	// can't be saved.
+	undo []func(context.Context)
+}
+
+type batchKey struct{}
+
+// withBatch returns a context whose writes are deferred to the batch.
+func withBatch(ctx context.Context) (context.Context, *batch) {
+	b := &batch{}
+	return context.WithValue(ctx, batchKey{}, b), b
+}
+
+// write calls fn right away, unless ctx belongs to a batch.
+func write(ctx context.Context, fn func(context.Context) error) error {
+	if b, ok := ctx.Value(batchKey{}).(*batch); ok {
+		b.writes = append(b.writes, fn)
+		return nil
+	}
+	return fn(ctx)
+}
+
+// onFailure calls fn if the writes of the batch of ctx can't be saved,
+// outside a batch the writes are already saved.
+func onFailure(ctx context.Context, fn func(context.Context)) {
+	if b, ok := ctx.Value(batchKey{}).(*batch); ok {
+		b.undo = append(b.undo, fn)
+	}
+}
+
+// flush saves the writes of the batch, they are dropped when any fails
+// and what was registered with onFailure is called.
+func (s Syncer) flush(ctx context.Context, b *batch) error {
+	if len(b.writes) == 0 {
+		return nil
+	}
+	writes, undo := b.writes, b.undo
+	b.writes, b.undo = nil, nil
+
+	ctx = context.WithoutCancel(ctx)
+	err := s.storage.Batch(ctx, func(ctx context.Context) error {
+		for _, fn := range writes {
+			if err := fn(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, fn := range undo {
+			fn(ctx)
+		}
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package syncer
+
+import (
+	"context"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+const bufferSummary = "Buffer"
+
+// buffer is a busy event created before or after a mirror.
+type buffer struct {
+	suffix   string
+	duration time.Duration
+	startsAt time.Time
+	endsAt   time.Time
+}
+
+// bufferEventID identifies the buffer as if it was an event of the source.
+func bufferEventID(srcEventID, suffix string) string {
+	return srcEventID + "#buffer-" + suffix
+}
+
+// newBufferEvent returns the buffer with the id and time of the mapping.
+func newBufferEvent(link *Link, m *internal.EventMapping) *Event {
+	return &Event{
+		ID:             m.EventID,
+		Type:           internal.EventTypeDefault,
+		Summary:        mirrorSummary(link.Destination, bufferSummary),
+		StartsAt:       m.StartsAt,
+		EndsAt:         m.EndsAt,
+		ResponseStatus: internal.Accepted,
+		Origin: &internal.Origin{
+			CalendarID: link.Source.ID,
+			EventID:    m.SrcEventID,
+		},
+	}
+}
+
+// saveMirror records where the mirror is and keeps its buffers in sync.
+// Errors are only logged, the mirror itself was already written.
+func (s Syncer) saveMirror(ctx context.Context, provider internal.Provider, link *Link, src, event *Event) {
+	dst := link.Destination
+
+	m := &internal.EventMapping{
+		CalendarID:    dst.ID,
+		EventID:       event.ID,
+		SrcCalendarID: link.Source.ID,
+		SrcEventID:    src.ID,
+		StartsAt:      event.StartsAt,
+		EndsAt:        event.EndsAt,
+	}
+	err := write(ctx, func(ctx context.Context) error {
+		return s.storage.SaveEvent(ctx, m)
+	})
+	if err != nil {
+		logf(s.output, dst, "Unable to update event on the storage %s: %v", event.ID, err)
+		return
+	}
+
+	opts := link.Options
+	buffers := []buffer{
+		{"before", opts.BufferBefore, event.StartsAt.Add(-opts.BufferBefore), event.StartsAt},
+		{"after", opts.BufferAfter, event.EndsAt, event.EndsAt.Add(opts.BufferAfter)},
+	}
+	for _, b := range buffers {
+		err := s.syncBuffer(ctx, provider, link, src, event, b)
+		if err != nil {
+			logf(s.output, dst, "Unable to sync buffer %s event %s: %v", b.suffix, event.ID, err)
+		}
+	}
+}
+
+// syncBuffer creates, updates or deletes the buffer of the mirror. Buffers
+// overlapping other events in dst are not created, this way two meetings
+// next to each other don't get buffers between them. The mappings of the
+// page are saved at its end, so mirrors changed in the same page are
+// checked with their previous time.
+func (s Syncer) syncBuffer(ctx context.Context, provider internal.Provider, link *Link, src, mirror *Event, b buffer) error {
+	dst := link.Destination
+	srcEventID := bufferEventID(src.ID, b.suffix)
+
+	id, err := s.storage.DestinationEventID(ctx, dst, link.Source, srcEventID)
+	if err != nil {
+		return err
+	}
+
+	wanted := b.duration > 0 && !mirror.StartsAt.IsZero()
+	if wanted {
+		overlaps, err := s.storage.Overlaps(ctx, dst, b.startsAt, b.endsAt, id, mirror.ID)
+		if err != nil {
+			return err
+		}
+		if overlaps {
+			logf(s.output, dst, "Skipping buffer %s event %s, it overlaps another event", b.suffix, mirror.ID)
+			wanted = false
+		}
+	}
+
+	event := newBufferEvent(link, &internal.EventMapping{
+		EventID:    id,
+		SrcEventID: srcEventID,
+		StartsAt:   b.startsAt,
+		EndsAt:     b.endsAt,
+	})
+	var op internal.Operation
+	switch {
+	case !wanted && id == "":
+		return nil
+	case !wanted:
+		op = internal.OperationDelete
+	case id == "":
+		op = internal.OperationCreate
+	default:
+		op = internal.OperationUpdate
+	}
+	if err := s.beforeHook(ctx, op, link, src, event); err != nil {
+		return err
+	}
+
+	before := s.previousMirror(ctx, provider, dst, id)
+	switch op {
+	case internal.OperationDelete:
+		err = s.deleteEvent(ctx, provider, dst, before, event)
+	case internal.OperationCreate:
+		err = s.createEvent(ctx, provider, dst, link.Source, srcEventID, event)
+	default:
+		err = s.updateEvent(ctx, provider, dst, before, event)
+	}
+	if err != nil {
+		return err
+	}
+
+	if op != internal.OperationDelete {
+		m := &internal.EventMapping{
+			CalendarID:    dst.ID,
+			EventID:       event.ID,
+			SrcCalendarID: link.Source.ID,
+			SrcEventID:    srcEventID,
+			ParentID:      mirror.ID,
+			StartsAt:      event.StartsAt,
+			EndsAt:        event.EndsAt,
+		}
+		err = write(ctx, func(ctx context.Context) error {
+			return s.storage.SaveEvent(ctx, m)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.afterHook(ctx, op, link, src, event)
+	return nil
+}
+
+// deleteBuffers deletes the events created around the mirror.
+func (s Syncer) deleteBuffers(ctx context.Context, provider internal.Provider, link *Link, src *Event, mirrorID string) {
+	dst := link.Destination
+	children, err := s.storage.ChildEvents(ctx, dst, mirrorID)
+	if err != nil {
+		logf(s.output, dst, "Unable to get buffers of event %s: %v", mirrorID, err)
+		return
+	}
+	for _, m := range children {
+		event := newBufferEvent(link, m)
+		if err := s.beforeHook(ctx, internal.OperationDelete, link, src, event); err != nil {
+			continue
+		}
+		before := s.previousMirror(ctx, provider, dst, m.EventID)
+		if err := s.deleteEvent(ctx, provider, dst, before, event); err != nil {
+			continue
+		}
+		s.afterHook(ctx, internal.OperationDelete, link, src, event)
+	}
+}
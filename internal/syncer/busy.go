@@ -0,0 +1,345 @@
+package syncer
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+const busySummary = "Busy"
+
+type interval struct {
+	startsAt time.Time
+	endsAt   time.Time
+}
+
+func (i interval) overlaps(b *internal.BusyBlock) bool {
+	return i.startsAt.Before(b.EndsAt) && b.StartsAt.Before(i.endsAt)
+}
+
+func (i interval) equal(b *internal.BusyBlock) bool {
+	return i.startsAt.Equal(b.StartsAt) && i.endsAt.Equal(b.EndsAt)
+}
+
+// syncBusyEvent records the time the source event keeps dst busy, the
+// blocks themselves are written by syncBusyBlocks.
+func (s Syncer) syncBusyEvent(ctx context.Context, link *Link, srcProviderID string, event *Event, ignoreEvent bool, report *LinkReport) (internal.Operation, error) {
+	dst, src := link.Destination, link.Source
+
+	free := event.ResponseStatus == internal.Cancelled ||
+		event.ResponseStatus == internal.Declined ||
+		event.Transparent ||
+		ignoreEvent ||
+		!event.StartsAt.Before(event.EndsAt)
+	m := &internal.EventMapping{
+		CalendarID:    dst.ID,
+		SrcCalendarID: src.ID,
+		SrcEventID:    srcProviderID,
+		StartsAt:      event.StartsAt,
+		EndsAt:        event.EndsAt,
+	}
+	// The busy time is only read once all events were received.
+	err := write(ctx, func(ctx context.Context) error {
+		if free {
+			return s.storage.DeleteBusySource(ctx, dst, src, srcProviderID)
+		}
+		return s.storage.SaveBusySource(ctx, m)
+	})
+	if err != nil {
+		return "", report.errorf(s.output, dst, "Unable to save busy time of event %s: %v", srcProviderID, err)
+	}
+	return "", nil
+}
+
+// busyIntervals merges the time of all source events, events overlapping
+// or next to each other end up in the same interval.
+func busyIntervals(sources []*internal.EventMapping) []interval {
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].StartsAt.Before(sources[j].StartsAt)
+	})
+
+	var res []interval
+	for _, m := range sources {
+		if n := len(res); n > 0 && !m.StartsAt.After(res[n-1].endsAt) {
+			if m.EndsAt.After(res[n-1].endsAt) {
+				res[n-1].endsAt = m.EndsAt
+			}
+			continue
+		}
+		res = append(res, interval{m.StartsAt, m.EndsAt})
+	}
+	return res
+}
+
+// syncBusyBlocks makes the blocks in dst cover the busy intervals of all
+// its sources. Existing blocks are kept, moved or resized whenever
+// possible, so merging two intervals updates one block and deletes the
+// other and splitting one creates only the new block.
+func (s Syncer) syncBusyBlocks(ctx context.Context, provider internal.Provider, dst *Calendar, report *LinkReport) error {
+	sources, err := s.storage.BusySources(ctx, dst, nil)
+	if err != nil {
+		return report.errorf(s.output, dst, "Unable to get busy time of the sources: %v", err)
+	}
+	blocks, err := s.storage.BusyBlocks(ctx, dst)
+	if err != nil {
+		return report.errorf(s.output, dst, "Unable to get busy blocks: %v", err)
+	}
+
+	used := make(map[*internal.BusyBlock]bool, len(blocks))
+	var pending []interval
+	for _, in := range busyIntervals(sources) {
+		var found bool
+		for _, b := range blocks {
+			if !used[b] && in.equal(b) {
+				used[b], found = true, true
+				break
+			}
+		}
+		if !found {
+			pending = append(pending, in)
+		}
+	}
+
+	for _, in := range pending {
+		var block *internal.BusyBlock
+		for _, b := range blocks {
+			if !used[b] && in.overlaps(b) {
+				block = b
+				break
+			}
+		}
+
+		if block == nil {
+			block = &internal.BusyBlock{CalendarID: dst.ID, StartsAt: in.startsAt, EndsAt: in.endsAt}
+			err := s.createBusyBlock(ctx, provider, dst, block)
+			report.add(block.EventID, internal.OperationCreate, err)
+			continue
+		}
+
+		used[block] = true
+		before := newBusyEvent(dst, block)
+		block.StartsAt, block.EndsAt = in.startsAt, in.endsAt
+		err := s.updateBusyBlock(ctx, provider, dst, before, block)
+		report.add(block.EventID, internal.OperationUpdate, err)
+	}
+
+	for _, b := range blocks {
+		if used[b] {
+			continue
+		}
+		err := s.deleteBusyBlock(ctx, provider, dst, newBusyEvent(dst, b))
+		report.add(b.EventID, internal.OperationDelete, err)
+	}
+	return nil
+}
+
+func newBusyEvent(dst *Calendar, b *internal.BusyBlock) *Event {
+	return &Event{
+		ID:             b.EventID,
+		Type:           internal.EventTypeDefault,
+		Summary:        busySummary,
+		StartsAt:       b.StartsAt,
+		EndsAt:         b.EndsAt,
+		ResponseStatus: internal.Accepted,
+		// Blocks don't come from a single source.
+		Origin: &internal.Origin{CalendarID: dst.ID},
+	}
+}
+
+// busyLink is the link given to the hooks for busy blocks, they don't
+// come from a single source.
+func busyLink(dst *Calendar) *Link {
+	return &Link{Destination: dst}
+}
+
+func (s Syncer) createBusyBlock(ctx context.Context, provider internal.Provider, dst *Calendar, b *internal.BusyBlock) error {
+	logf(s.output, dst, "Creating busy block on %s until %s", formatDateTime(b.StartsAt), formatDateTime(b.EndsAt))
+
+	link := busyLink(dst)
+	event := newBusyEvent(dst, b)
+	if err := s.beforeHook(ctx, internal.OperationCreate, link, nil, event); err != nil {
+		return err
+	}
+	newEvent, err := provider.CreateEvent(ctx, dst, event)
+	if err != nil {
+		logf(s.output, dst, "Unable to create event on the provider: %v", err)
+		return err
+	}
+	b.EventID = newEvent.ID
+
+	err = s.storage.SaveBusyBlock(ctx, b)
+	if err != nil {
+		logf(s.output, dst, "Unable to create busy block on the storage: %v", err)
+		_ = provider.DeleteEvent(ctx, dst, newEvent.ID)
+		return err
+	}
+
+	s.audit(ctx, &internal.AuditEntry{
+		CalendarID: dst.ID,
+		EventID:    newEvent.ID,
+		Kind:       internal.AuditBusy,
+		Operation:  internal.OperationCreate,
+		After:      newEvent,
+	}, nil)
+	s.afterHook(ctx, internal.OperationCreate, link, nil, newEvent)
+	return nil
+}
+
+// updateBusyBlock moves the block to its new time, before is the block
+// as it was in dst.
+func (s Syncer) updateBusyBlock(ctx context.Context, provider internal.Provider, dst *Calendar, before *Event, b *internal.BusyBlock) error {
+	logf(s.output, dst, "Updating busy block %s to %s until %s", b.EventID, formatDateTime(b.StartsAt), formatDateTime(b.EndsAt))
+
+	link := busyLink(dst)
+	after := newBusyEvent(dst, b)
+	if err := s.beforeHook(ctx, internal.OperationUpdate, link, nil, after); err != nil {
+		return err
+	}
+	err := provider.UpdateEvent(ctx, dst, after)
+	if err != nil {
+		logf(s.output, dst, "Unable to update event on the provider %s: %v", b.EventID, err)
+		return err
+	}
+	s.audit(ctx, &internal.AuditEntry{
+		CalendarID: dst.ID,
+		EventID:    b.EventID,
+		Kind:       internal.AuditBusy,
+		Operation:  internal.OperationUpdate,
+		Before:     before,
+		After:      after,
+	}, nil)
+
+	err = s.storage.SaveBusyBlock(ctx, b)
+	if err != nil {
+		logf(s.output, dst, "Unable to update busy block on the storage %s: %v", b.EventID, err)
+		return err
+	}
+	s.afterHook(ctx, internal.OperationUpdate, link, nil, after)
+	return nil
+}
+
+// deleteBusyBlock removes the block from dst, before is the block as it
+// was in dst.
+func (s Syncer) deleteBusyBlock(ctx context.Context, provider internal.Provider, dst *Calendar, before *Event) error {
+	eventID := before.ID
+	logf(s.output, dst, "Deleting busy block %s", eventID)
+
+	link := busyLink(dst)
+	if err := s.beforeHook(ctx, internal.OperationDelete, link, nil, before); err != nil {
+		return err
+	}
+	err := provider.DeleteEvent(ctx, dst, eventID)
+	if err != nil {
+		logf(s.output, dst, "Unable to delete event from provider %s: %v", eventID, err)
+		return err
+	}
+	s.audit(ctx, &internal.AuditEntry{
+		CalendarID: dst.ID,
+		EventID:    eventID,
+		Kind:       internal.AuditBusy,
+		Operation:  internal.OperationDelete,
+		Before:     before,
+	}, nil)
+
+	err = s.storage.DeleteBusyBlock(ctx, dst, eventID)
+	if err != nil {
+		logf(s.output, dst, "Unable to delete busy block from storage %s: %v", eventID, err)
+		return err
+	}
+	s.afterHook(ctx, internal.OperationDelete, link, nil, before)
+	return nil
+}
+
+// reconcileBusy refreshes the busy time of all sources of dst and fixes
+// its blocks, dstEvents are the events found in dst.
+func (s Syncer) reconcileBusy(ctx context.Context, provider internal.Provider, dst *Calendar, links []*Link, dstEvents map[string]*Event, report *ReconcileReport) error {
+	for _, link := range links {
+		src := link.Source
+		srcProvider, err := s.mux.Get(src.Account.Platform)
+		if err != nil {
+			logf(s.output, dst, "Unable to load source provider: %v", err)
+			return err
+		}
+		srcEvents, err := s.listSourceEvents(ctx, srcProvider, src)
+		if err != nil {
+			logf(s.output, dst, "Unable to get list of events from %s: %v", src, err)
+			return err
+		}
+		for _, event := range srcEvents {
+			event := *event
+			if _, err := s.syncEvent(ctx, provider, link, &event, nil); err != nil {
+				return err
+			}
+		}
+
+		sources, err := s.storage.BusySources(ctx, dst, src)
+		if err != nil {
+			logf(s.output, dst, "Unable to get busy time of %s: %v", src, err)
+			return err
+		}
+		for _, m := range sources {
+			if _, ok := srcEvents[m.SrcEventID]; ok {
+				continue
+			}
+			err := s.storage.DeleteBusySource(ctx, dst, src, m.SrcEventID)
+			if err != nil {
+				logf(s.output, dst, "Unable to delete busy time of event %s: %v", m.SrcEventID, err)
+				return err
+			}
+		}
+	}
+
+	blocks, err := s.storage.BusyBlocks(ctx, dst)
+	if err != nil {
+		logf(s.output, dst, "Unable to get busy blocks: %v", err)
+		return err
+	}
+	for _, b := range blocks {
+		if _, exists := dstEvents[b.EventID]; exists {
+			continue
+		}
+		logf(s.output, dst, "Removing busy block %s, it doesn't exist anymore", b.EventID)
+
+		err := s.storage.DeleteBusyBlock(ctx, dst, b.EventID)
+		if err != nil {
+			logf(s.output, dst, "Unable to delete busy block from storage %s: %v", b.EventID, err)
+			report.Failed++
+			continue
+		}
+		report.DroppedMappings++
+	}
+
+	var lr LinkReport
+	if err := s.syncBusyBlocks(ctx, provider, dst, &lr); err != nil {
+		return err
+	}
+	report.Recreated += lr.Created
+	report.Deleted += lr.Deleted
+	report.Failed += lr.Failed
+
+	blocks, err = s.storage.BusyBlocks(ctx, dst)
+	if err != nil {
+		logf(s.output, dst, "Unable to get busy blocks: %v", err)
+		return err
+	}
+	known := make(map[string]bool, len(blocks))
+	for _, b := range blocks {
+		known[b.EventID] = true
+	}
+	for id, event := range dstEvents {
+		if event.Origin == nil || event.Origin.CalendarID != dst.ID || known[id] {
+			continue
+		}
+		logf(s.output, dst, "Deleting orphan event %s: %q on %s", id, event.Summary, formatDateTime(event.StartsAt))
+
+		err := s.deleteOrphan(ctx, provider, dst, event)
+		if err != nil {
+			report.Failed++
+			continue
+		}
+		report.Orphans++
+	}
+	return nil
+}
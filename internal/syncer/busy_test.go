@@ -0,0 +1,58 @@
+package syncer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+func TestBusyIntervals(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 3, 2, hour, min, 0, 0, time.UTC)
+	}
+	source := func(startsAt, endsAt time.Time) *internal.EventMapping {
+		return &internal.EventMapping{StartsAt: startsAt, EndsAt: endsAt}
+	}
+
+	tests := []struct {
+		name    string
+		sources []*internal.EventMapping
+		want    []interval
+	}{
+		{"no events", nil, nil},
+		{
+			name:    "one event",
+			sources: []*internal.EventMapping{source(at(9, 0), at(10, 0))},
+			want:    []interval{{at(9, 0), at(10, 0)}},
+		},
+		{
+			name:    "apart",
+			sources: []*internal.EventMapping{source(at(11, 0), at(12, 0)), source(at(9, 0), at(10, 0))},
+			want:    []interval{{at(9, 0), at(10, 0)}, {at(11, 0), at(12, 0)}},
+		},
+		{
+			name:    "overlapping",
+			sources: []*internal.EventMapping{source(at(9, 30), at(11, 0)), source(at(9, 0), at(10, 0))},
+			want:    []interval{{at(9, 0), at(11, 0)}},
+		},
+		{
+			name:    "next to each other",
+			sources: []*internal.EventMapping{source(at(9, 0), at(10, 0)), source(at(10, 0), at(10, 30))},
+			want:    []interval{{at(9, 0), at(10, 30)}},
+		},
+		{
+			name:    "inside another",
+			sources: []*internal.EventMapping{source(at(9, 0), at(12, 0)), source(at(10, 0), at(11, 0)), source(at(13, 0), at(14, 0))},
+			want:    []interval{{at(9, 0), at(12, 0)}, {at(13, 0), at(14, 0)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := busyIntervals(tt.sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("busyIntervals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
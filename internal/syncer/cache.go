@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"context"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// cacheEvent saves the latest state of the source event, errors are only
+// logged as the events are synced anyway.
+func (s Syncer) cacheEvent(ctx context.Context, src *Calendar, event *Event) {
+	err := write(ctx, func(ctx context.Context) error {
+		return s.storage.SaveSourceEvent(ctx, src, event)
+	})
+	if err != nil {
+		logf(s.output, src, "Unable to save event %s: %v", event.ID, err)
+	}
+}
+
+// pruneEvents removes the events of src that weren't received since t,
+// it must be called after receiving all events of src.
+func (s Syncer) pruneEvents(ctx context.Context, src *Calendar, t time.Time) {
+	err := s.storage.DeleteSourceEventsBefore(ctx, src, t)
+	if err != nil {
+		logf(s.output, src, "Unable to delete events that don't exist anymore: %v", err)
+	}
+}
+
+// listSourceEvents returns all events from src indexed by their id, they
+// are saved as the latest state of the source.
+func (s Syncer) listSourceEvents(ctx context.Context, provider internal.Provider, src *Calendar) (map[string]*Event, error) {
+	start := time.Now()
+	events, err := s.listEvents(ctx, provider, src, true)
+	if err != nil {
+		return nil, err
+	}
+	err = s.storage.Batch(ctx, func(ctx context.Context) error {
+		for _, event := range events {
+			s.cacheEvent(ctx, src, event)
+		}
+		s.pruneEvents(ctx, src, start)
+		return nil
+	})
+	return events, err
+}
@@ -0,0 +1,127 @@
+package syncer
+
+import (
+	"context"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// dedupKey identifies the same event received from different sources.
+func dedupKey(e *Event) string {
+	start := e.StartsAt.UTC().Format(time.RFC3339)
+	if e.ICalUID != "" {
+		return e.ICalUID + "|" + start
+	}
+	return e.Organizer + "|" + start + "|" + e.Summary
+}
+
+// syncDedupEvent mirrors the event into dst, merging it with the same
+// event received from other sources. The mirror is only deleted when all
+// sources cancelled or declined it.
+func (s Syncer) syncDedupEvent(ctx context.Context, dstProvider internal.Provider, link *Link, srcEvent, event *Event, ignoreEvent bool, report *LinkReport) (internal.Operation, error) {
+	dst, src := link.Destination, link.Source
+	m := &internal.EventMapping{
+		CalendarID:    dst.ID,
+		SrcCalendarID: src.ID,
+		SrcEventID:    event.ID,
+	}
+
+	var err error
+	m.EventID, err = s.storage.EventSourceID(ctx, dst, src, m.SrcEventID)
+	if err != nil {
+		return "", report.errorf(s.output, dst, "Unable to get destination event id %s: %v", m.SrcEventID, err)
+	}
+
+	active := event.ResponseStatus != internal.Cancelled &&
+		event.ResponseStatus != internal.Declined &&
+		!ignoreEvent
+	if !active {
+		if m.EventID == "" {
+			return "", nil
+		}
+		return s.leaveDedupEvent(ctx, dstProvider, link, m, srcEvent, event)
+	}
+
+	key := dedupKey(event)
+	if m.EventID == "" {
+		m.EventID, err = s.storage.DedupEventID(ctx, dst, key)
+		if err != nil {
+			return "", report.errorf(s.output, dst, "Unable to get destination event id %s: %v", m.SrcEventID, err)
+		}
+	}
+
+	op := internal.OperationUpdate
+	if m.EventID == "" {
+		op = internal.OperationCreate
+		err = s.applyEvent(ctx, op, dstProvider, link, srcEvent, event)
+	} else {
+		logf(s.output, dst, "Event %s from %s is the same as event %s", m.SrcEventID, src, m.EventID)
+		event.ID = m.EventID
+		err = s.applyEvent(ctx, op, dstProvider, link, srcEvent, event)
+	}
+	if err != nil {
+		return op, err
+	}
+
+	m.EventID = event.ID
+	err = write(ctx, func(ctx context.Context) error {
+		return s.storage.SaveEventSource(ctx, m, key, true)
+	})
+	if err != nil {
+		logf(s.output, dst, "Unable to save source of event %s: %v", m.EventID, err)
+	}
+	return op, err
+}
+
+// leaveDedupEvent removes the source event from the ones contributing to
+// the mirror, the mirror is deleted if no other source contributes to it.
+func (s Syncer) leaveDedupEvent(ctx context.Context, dstProvider internal.Provider, link *Link, m *internal.EventMapping, srcEvent, event *Event) (internal.Operation, error) {
+	dst := link.Destination
+	err := write(ctx, func(ctx context.Context) error {
+		return s.storage.SaveEventSource(ctx, m, "", false)
+	})
+	if err != nil {
+		logf(s.output, dst, "Unable to save source of event %s: %v", m.EventID, err)
+		return "", err
+	}
+	active, err := s.storage.EventSources(ctx, dst, m.EventID)
+	if err != nil {
+		logf(s.output, dst, "Unable to get sources of event %s: %v", m.EventID, err)
+		return "", err
+	}
+	// The source may still be active in the storage while the page
+	// isn't saved.
+	var sources []*internal.EventMapping
+	for _, source := range active {
+		if source.SrcCalendarID != m.SrcCalendarID || source.SrcEventID != m.SrcEventID {
+			sources = append(sources, source)
+		}
+	}
+
+	if len(sources) > 0 {
+		logf(s.output, dst, "Keeping event %s, %d other source(s) still have it", m.EventID, len(sources))
+
+		// Make sure the mapping points to a source that still has the event.
+		err := write(ctx, func(ctx context.Context) error {
+			return s.storage.ReassignEvent(ctx, sources[0])
+		})
+		if err != nil {
+			logf(s.output, dst, "Unable to update event on the storage %s: %v", m.EventID, err)
+		}
+		return "", err
+	}
+
+	event.ID = m.EventID
+	err = s.applyEvent(ctx, internal.OperationDelete, dstProvider, link, srcEvent, event)
+	if err != nil {
+		return internal.OperationDelete, err
+	}
+	err = write(ctx, func(ctx context.Context) error {
+		return s.storage.DeleteEventSources(ctx, dst, m.EventID)
+	})
+	if err != nil {
+		logf(s.output, dst, "Unable to delete sources of event from storage %s: %v", m.EventID, err)
+	}
+	return internal.OperationDelete, err
+}
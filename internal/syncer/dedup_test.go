@@ -0,0 +1,82 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+func TestDedupKey(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	berlin := time.FixedZone("CET", 3600)
+
+	tests := []struct {
+		name  string
+		event *Event
+		want  string
+	}{
+		{
+			name:  "ical uid",
+			event: &Event{ICalUID: "abc@google.com", Summary: "Standup", StartsAt: start},
+			want:  "abc@google.com|2026-03-02T09:00:00Z",
+		},
+		{
+			name:  "ical uid in other time zone",
+			event: &Event{ICalUID: "abc@google.com", StartsAt: start.In(berlin)},
+			want:  "abc@google.com|2026-03-02T09:00:00Z",
+		},
+		{
+			name:  "without ical uid",
+			event: &Event{Organizer: "bob@example.com", Summary: "Standup", StartsAt: start},
+			want:  "bob@example.com|2026-03-02T09:00:00Z|Standup",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dedupKey(tt.event); got != tt.want {
+				t.Errorf("dedupKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupLeave(t *testing.T) {
+	ctx := context.Background()
+	work, home := testCalendar("alice", "work"), testCalendar("bob", "home")
+	dst := testCalendar("alice", "personal")
+	dst.Mode = internal.CalendarModeDedup
+	workLink := &Link{ID: 1, Source: work, Destination: dst, Status: internal.LinkActive}
+	homeLink := &Link{ID: 2, Source: home, Destination: dst, Status: internal.LinkActive}
+	storage := newFakeStorage(workLink, homeLink)
+	provider := newFakeProvider()
+	for _, src := range []*Calendar{work, home} {
+		event := testEvent("s1", "Planning", 9)
+		event.ICalUID = "planning"
+		provider.add(src, event)
+	}
+	s := newTestSyncer(storage, provider)
+
+	if _, err := s.Sync(ctx, nil, false, internal.Date{}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	mirror, _ := storage.EventSourceID(ctx, dst, work, "s1")
+	if mirror == "" {
+		t.Fatal("got no mirror of s1")
+	}
+
+	for i, link := range []*Link{workLink, homeLink} {
+		event := *provider.events[link.Source.ID]["s1"]
+		event.ResponseStatus = internal.Declined
+		provider.add(link.Source, &event)
+
+		if _, err := s.SyncCalendar(ctx, link, internal.Date{}); err != nil {
+			t.Fatalf("SyncCalendar(%s) = %v", link.Source, err)
+		}
+		// The mirror is only deleted once no source has the event.
+		if kept, want := provider.events[dst.ID][mirror] != nil, i == 0; kept != want {
+			t.Errorf("after declining on %s mirror kept = %v, want %v", link.Source, kept, want)
+		}
+	}
+}
@@ -0,0 +1,642 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+	"golang.org/x/oauth2"
+)
+
+var errFake = errors.New("fake error")
+
+// fakeStorage keeps in memory what the sqlite storage keeps in its tables.
+type fakeStorage struct {
+	links     []*Link
+	events    map[string]*internal.EventMapping
+	mirrors   map[string]*Event
+	sources   map[string]*fakeEventSource
+	retries   map[string]*internal.Retry
+	busy      map[string]*internal.EventMapping
+	blocks    map[string]*internal.BusyBlock
+	srcEvents map[string]*internal.SourceEvent
+	audit     []*internal.AuditEntry
+	// failBatch makes the batches fail without saving anything.
+	failBatch bool
+}
+
+type fakeEventSource struct {
+	m      internal.EventMapping
+	key    string
+	active bool
+}
+
+func newFakeStorage(links ...*Link) *fakeStorage {
+	return &fakeStorage{
+		links:     links,
+		events:    make(map[string]*internal.EventMapping),
+		mirrors:   make(map[string]*Event),
+		sources:   make(map[string]*fakeEventSource),
+		retries:   make(map[string]*internal.Retry),
+		busy:      make(map[string]*internal.EventMapping),
+		blocks:    make(map[string]*internal.BusyBlock),
+		srcEvents: make(map[string]*internal.SourceEvent),
+	}
+}
+
+func fakeKey(ids ...string) string {
+	return strings.Join(ids, "|")
+}
+
+// sortedKeys returns the keys of m in order, so the fake always answers
+// the same way.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func copyMapping(m *internal.EventMapping) *internal.EventMapping {
+	c := *m
+	return &c
+}
+
+func (s *fakeStorage) calendar(id string) *Calendar {
+	for _, l := range s.links {
+		if l.Source.ID == id {
+			return l.Source
+		}
+		if l.Destination.ID == id {
+			return l.Destination
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) DestinationCalendars(_ context.Context, calIDs []string) ([]*Calendar, error) {
+	var res []*Calendar
+	seen := make(map[string]bool)
+	for _, l := range s.links {
+		dst := l.Destination
+		if seen[dst.ID] {
+			continue
+		}
+		seen[dst.ID] = true
+		if len(calIDs) == 0 || contains(calIDs, dst.ID) {
+			res = append(res, dst)
+		}
+	}
+	return res, nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeStorage) Links(_ context.Context, dst *Calendar) ([]*Link, error) {
+	var res []*Link
+	for _, l := range s.links {
+		if l.Destination.ID == dst.ID {
+			c := *l
+			res = append(res, &c)
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStorage) DestinationEventID(_ context.Context, dst, src *Calendar, srcEventID string) (string, error) {
+	for _, k := range sortedKeys(s.events) {
+		m := s.events[k]
+		if m.CalendarID == dst.ID && m.SrcCalendarID == src.ID && m.SrcEventID == srcEventID {
+			return m.EventID, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *fakeStorage) UnclaimedEventID(_ context.Context, dst *Calendar, srcEventID string) (string, error) {
+	for _, k := range sortedKeys(s.events) {
+		m := s.events[k]
+		if m.CalendarID == dst.ID && m.SrcCalendarID == "" && m.SrcEventID == srcEventID {
+			return m.EventID, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *fakeStorage) ClaimEvent(_ context.Context, dst, src *Calendar, dstEventID string) error {
+	if m := s.events[fakeKey(dst.ID, dstEventID)]; m != nil && m.SrcCalendarID == "" {
+		m.SrcCalendarID = src.ID
+	}
+	return nil
+}
+
+func (s *fakeStorage) EventMappings(_ context.Context, dst, src *Calendar) ([]*internal.EventMapping, error) {
+	var res []*internal.EventMapping
+	for _, k := range sortedKeys(s.events) {
+		m := s.events[k]
+		if m.CalendarID == dst.ID && (src == nil || m.SrcCalendarID == src.ID) {
+			res = append(res, copyMapping(m))
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStorage) ClaimEvents(_ context.Context, dst, src *Calendar) error {
+	claimed := make(map[string]bool)
+	for _, m := range s.events {
+		if m.CalendarID == dst.ID && m.SrcCalendarID == src.ID {
+			claimed[m.SrcEventID] = true
+		}
+	}
+	for _, m := range s.events {
+		if m.CalendarID == dst.ID && m.SrcCalendarID == "" && !claimed[m.SrcEventID] {
+			m.SrcCalendarID = src.ID
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) CreateEvent(_ context.Context, dst, src *Calendar, dstEventID, srcEventID string) error {
+	k := fakeKey(dst.ID, dstEventID)
+	if s.events[k] != nil {
+		return fmt.Errorf("event %s already exists", dstEventID)
+	}
+	s.events[k] = &internal.EventMapping{
+		CalendarID:    dst.ID,
+		EventID:       dstEventID,
+		SrcCalendarID: src.ID,
+		SrcEventID:    srcEventID,
+	}
+	return nil
+}
+
+func (s *fakeStorage) SaveEvent(_ context.Context, m *internal.EventMapping) error {
+	s.events[fakeKey(m.CalendarID, m.EventID)] = copyMapping(m)
+	return nil
+}
+
+func (s *fakeStorage) ChildEvents(_ context.Context, cal *Calendar, eventID string) ([]*internal.EventMapping, error) {
+	var res []*internal.EventMapping
+	for _, k := range sortedKeys(s.events) {
+		m := s.events[k]
+		if m.CalendarID == cal.ID && m.ParentID == eventID {
+			res = append(res, copyMapping(m))
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStorage) Overlaps(_ context.Context, cal *Calendar, startsAt, endsAt time.Time, exclude ...string) (bool, error) {
+	for _, m := range s.events {
+		if m.CalendarID != cal.ID || m.StartsAt.IsZero() || contains(exclude, m.EventID) {
+			continue
+		}
+		if m.StartsAt.Before(endsAt) && m.EndsAt.After(startsAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeStorage) DeleteEvent(_ context.Context, cal *Calendar, eventID string) error {
+	delete(s.events, fakeKey(cal.ID, eventID))
+	delete(s.mirrors, fakeKey(cal.ID, eventID))
+	return nil
+}
+
+func (s *fakeStorage) SaveMirror(_ context.Context, cal *Calendar, event *Event) error {
+	k := fakeKey(cal.ID, event.ID)
+	if s.events[k] != nil {
+		e := *event
+		s.mirrors[k] = &e
+	}
+	return nil
+}
+
+func (s *fakeStorage) Mirror(_ context.Context, cal *Calendar, eventID string) (*Event, error) {
+	e := s.mirrors[fakeKey(cal.ID, eventID)]
+	if e == nil {
+		return nil, nil
+	}
+	c := *e
+	return &c, nil
+}
+
+func (s *fakeStorage) SaveLastSync(_ context.Context, link *Link, lastSync string) error {
+	for _, l := range s.links {
+		if l.ID == link.ID {
+			l.LastSync = lastSync
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) Retry(_ context.Context, dst, src *Calendar, srcEventID string) (*internal.Retry, error) {
+	r := s.retries[fakeKey(dst.ID, src.ID, srcEventID)]
+	if r == nil {
+		return nil, nil
+	}
+	c := *r
+	return &c, nil
+}
+
+func (s *fakeStorage) DueRetries(_ context.Context, dst, src *Calendar, now time.Time) ([]*internal.Retry, error) {
+	var res []*internal.Retry
+	for _, k := range sortedKeys(s.retries) {
+		r := s.retries[k]
+		if r.CalendarID == dst.ID && r.SrcCalendarID == src.ID && r.Status == internal.RetryPending && !r.NextAttemptAt.After(now) {
+			c := *r
+			res = append(res, &c)
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStorage) SaveRetry(_ context.Context, r *internal.Retry) error {
+	c := *r
+	s.retries[fakeKey(r.CalendarID, r.SrcCalendarID, r.Event.ID)] = &c
+	return nil
+}
+
+func (s *fakeStorage) DeleteRetry(_ context.Context, dst, src *Calendar, srcEventID string) error {
+	delete(s.retries, fakeKey(dst.ID, src.ID, srcEventID))
+	return nil
+}
+
+func (s *fakeStorage) EventSourceID(_ context.Context, dst, src *Calendar, srcEventID string) (string, error) {
+	if es := s.sources[fakeKey(dst.ID, src.ID, srcEventID)]; es != nil {
+		return es.m.EventID, nil
+	}
+	return "", nil
+}
+
+func (s *fakeStorage) DedupEventID(_ context.Context, dst *Calendar, key string) (string, error) {
+	for _, k := range sortedKeys(s.sources) {
+		es := s.sources[k]
+		if es.m.CalendarID == dst.ID && es.key == key {
+			return es.m.EventID, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *fakeStorage) EventSources(_ context.Context, dst *Calendar, dstEventID string) ([]*internal.EventMapping, error) {
+	var res []*internal.EventMapping
+	for _, k := range sortedKeys(s.sources) {
+		es := s.sources[k]
+		if es.m.CalendarID == dst.ID && es.m.EventID == dstEventID && es.active {
+			res = append(res, copyMapping(&es.m))
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStorage) SaveEventSource(_ context.Context, m *internal.EventMapping, key string, active bool) error {
+	k := fakeKey(m.CalendarID, m.SrcCalendarID, m.SrcEventID)
+	if es := s.sources[k]; es != nil && key == "" {
+		key = es.key
+	}
+	s.sources[k] = &fakeEventSource{m: *m, key: key, active: active}
+	return nil
+}
+
+func (s *fakeStorage) DeleteEventSources(_ context.Context, dst *Calendar, dstEventID string) error {
+	for k, es := range s.sources {
+		if es.m.CalendarID == dst.ID && es.m.EventID == dstEventID {
+			delete(s.sources, k)
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) ReassignEvent(_ context.Context, m *internal.EventMapping) error {
+	if e := s.events[fakeKey(m.CalendarID, m.EventID)]; e != nil {
+		e.SrcCalendarID, e.SrcEventID = m.SrcCalendarID, m.SrcEventID
+	}
+	return nil
+}
+
+func (s *fakeStorage) MirrorOrigin(_ context.Context, cal *Calendar, eventID string) (*internal.Origin, error) {
+	for _, k := range sortedKeys(s.events) {
+		m := s.events[k]
+		c := s.calendar(m.CalendarID)
+		if m.EventID != eventID || c == nil {
+			continue
+		}
+		if c.Account.ID() == cal.Account.ID() && c.ProviderID == cal.ProviderID {
+			return &internal.Origin{CalendarID: m.SrcCalendarID, EventID: m.SrcEventID}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStorage) BusySources(_ context.Context, dst, src *Calendar) ([]*internal.EventMapping, error) {
+	var res []*internal.EventMapping
+	for _, m := range s.busy {
+		if m.CalendarID == dst.ID && (src == nil || m.SrcCalendarID == src.ID) {
+			res = append(res, copyMapping(m))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].StartsAt.Before(res[j].StartsAt)
+	})
+	return res, nil
+}
+
+func (s *fakeStorage) SaveBusySource(_ context.Context, m *internal.EventMapping) error {
+	s.busy[fakeKey(m.CalendarID, m.SrcCalendarID, m.SrcEventID)] = copyMapping(m)
+	return nil
+}
+
+func (s *fakeStorage) DeleteBusySource(_ context.Context, dst, src *Calendar, srcEventID string) error {
+	delete(s.busy, fakeKey(dst.ID, src.ID, srcEventID))
+	return nil
+}
+
+func (s *fakeStorage) BusyBlocks(_ context.Context, dst *Calendar) ([]*internal.BusyBlock, error) {
+	var res []*internal.BusyBlock
+	for _, b := range s.blocks {
+		if b.CalendarID == dst.ID {
+			c := *b
+			res = append(res, &c)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].StartsAt.Before(res[j].StartsAt)
+	})
+	return res, nil
+}
+
+func (s *fakeStorage) SaveBusyBlock(_ context.Context, b *internal.BusyBlock) error {
+	c := *b
+	s.blocks[fakeKey(b.CalendarID, b.EventID)] = &c
+	return nil
+}
+
+func (s *fakeStorage) DeleteBusyBlock(_ context.Context, dst *Calendar, eventID string) error {
+	delete(s.blocks, fakeKey(dst.ID, eventID))
+	return nil
+}
+
+func (s *fakeStorage) SaveSourceEvent(_ context.Context, src *Calendar, event *Event) error {
+	k := fakeKey(src.ID, event.ID)
+	if event.ResponseStatus == internal.Cancelled {
+		delete(s.srcEvents, k)
+		return nil
+	}
+	e := *event
+	s.srcEvents[k] = &internal.SourceEvent{CalendarID: src.ID, Event: &e, UpdatedAt: time.Now()}
+	return nil
+}
+
+func (s *fakeStorage) SourceEvent(_ context.Context, src *Calendar, eventID string) (*internal.SourceEvent, error) {
+	cached := s.srcEvents[fakeKey(src.ID, eventID)]
+	if cached == nil {
+		return nil, nil
+	}
+	e := *cached.Event
+	return &internal.SourceEvent{CalendarID: cached.CalendarID, Event: &e, UpdatedAt: cached.UpdatedAt}, nil
+}
+
+func (s *fakeStorage) DeleteSourceEventsBefore(_ context.Context, src *Calendar, t time.Time) error {
+	for k, cached := range s.srcEvents {
+		if cached.CalendarID == src.ID && cached.UpdatedAt.Before(t) {
+			delete(s.srcEvents, k)
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) EventMapping(_ context.Context, cal *Calendar, eventID string) (*internal.EventMapping, error) {
+	m := s.events[fakeKey(cal.ID, eventID)]
+	if m == nil {
+		return nil, nil
+	}
+	return copyMapping(m), nil
+}
+
+func (s *fakeStorage) SaveAudit(_ context.Context, e *internal.AuditEntry) error {
+	e.ID = int64(len(s.audit) + 1)
+	c := *e
+	s.audit = append(s.audit, &c)
+	return nil
+}
+
+func (s *fakeStorage) AuditEntries(_ context.Context, runID int64) ([]*internal.AuditEntry, error) {
+	var res []*internal.AuditEntry
+	for _, e := range s.audit {
+		if e.RunID == runID {
+			c := *e
+			res = append(res, &c)
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStorage) SetAuditUndone(_ context.Context, e *internal.AuditEntry) error {
+	s.audit[e.ID-1].UndoneAt = e.UndoneAt
+	return nil
+}
+
+func (s *fakeStorage) Batch(ctx context.Context, fn func(context.Context) error) error {
+	if s.failBatch {
+		return errFake
+	}
+	return fn(ctx)
+}
+
+// fakeProvider keeps the events of each calendar in memory.
+type fakeProvider struct {
+	events map[string]map[string]*Event
+	lastID int
+	// invalidToken makes the incremental sync fail as if the sync token
+	// had expired.
+	invalidToken bool
+	// fail makes the operations on the events with these ids fail.
+	fail map[string]bool
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		events: make(map[string]map[string]*Event),
+		fail:   make(map[string]bool),
+	}
+}
+
+// add saves the event in cal as if it was created by someone else.
+func (p *fakeProvider) add(cal *Calendar, event *Event) {
+	if p.events[cal.ID] == nil {
+		p.events[cal.ID] = make(map[string]*Event)
+	}
+	e := *event
+	p.events[cal.ID][event.ID] = &e
+}
+
+// list returns the events of cal ordered by id.
+func (p *fakeProvider) list(cal *Calendar) []*Event {
+	events := p.events[cal.ID]
+	var res []*Event
+	for _, id := range sortedKeys(events) {
+		e := *events[id]
+		res = append(res, &e)
+	}
+	return res
+}
+
+func (p *fakeProvider) Login(context.Context, func(string)) (*oauth2.Token, error) {
+	return nil, errFake
+}
+
+func (p *fakeProvider) Events(_ context.Context, cal *Calendar, _ internal.Date) (internal.Iterator, error) {
+	return &sliceIterator{events: p.list(cal)}, nil
+}
+
+func (p *fakeProvider) NewEventsFrom(_ context.Context, cal *Calendar, _ internal.Date) (internal.Iterator, error) {
+	return &sliceIterator{events: p.list(cal), lastSync: "token"}, nil
+}
+
+func (p *fakeProvider) NewEventsSince(_ context.Context, cal *Calendar, _ string) (internal.Iterator, error) {
+	if p.invalidToken {
+		return &sliceIterator{err: internal.ErrInvalidSyncToken}, nil
+	}
+	return &sliceIterator{events: p.list(cal), lastSync: "token"}, nil
+}
+
+func (p *fakeProvider) Event(_ context.Context, cal *Calendar, id string) (*Event, error) {
+	e := p.events[cal.ID][id]
+	if e == nil {
+		return nil, nil
+	}
+	c := *e
+	return &c, nil
+}
+
+func (p *fakeProvider) CreateEvent(_ context.Context, cal *Calendar, event *Event) (*Event, error) {
+	if p.fail[event.ID] {
+		return nil, errFake
+	}
+	p.lastID++
+	e := *event
+	e.ID = fmt.Sprintf("m%d", p.lastID)
+	p.add(cal, &e)
+	return &e, nil
+}
+
+func (p *fakeProvider) UpdateEvent(_ context.Context, cal *Calendar, event *Event) error {
+	if p.fail[event.ID] {
+		return errFake
+	}
+	if p.events[cal.ID][event.ID] == nil {
+		return fmt.Errorf("event %s not found", event.ID)
+	}
+	p.add(cal, event)
+	return nil
+}
+
+func (p *fakeProvider) DeleteEvent(_ context.Context, cal *Calendar, id string) error {
+	if p.fail[id] {
+		return errFake
+	}
+	delete(p.events[cal.ID], id)
+	return nil
+}
+
+type sliceIterator struct {
+	events   []*Event
+	event    *Event
+	lastSync string
+	err      error
+}
+
+func (it *sliceIterator) Next() bool {
+	if len(it.events) == 0 {
+		return false
+	}
+	it.event, it.events = it.events[0], it.events[1:]
+	return true
+}
+
+func (it *sliceIterator) Event() *Event    { return it.event }
+func (it *sliceIterator) LastSync() string { return it.lastSync }
+func (it *sliceIterator) Err() error       { return it.err }
+
+// fakeHooks vetoes the operation veto and calls change with the event of
+// the other operations.
+type fakeHooks struct {
+	NopHooks
+	veto   internal.Operation
+	change func(internal.Operation, *Event)
+}
+
+func (h fakeHooks) BeforeCreate(_ context.Context, _ *Link, _, dst *Event) error {
+	return h.before(internal.OperationCreate, dst)
+}
+
+func (h fakeHooks) BeforeUpdate(_ context.Context, _ *Link, _, dst *Event) error {
+	return h.before(internal.OperationUpdate, dst)
+}
+
+func (h fakeHooks) BeforeDelete(_ context.Context, _ *Link, _, dst *Event) error {
+	return h.before(internal.OperationDelete, dst)
+}
+
+func (h fakeHooks) before(op internal.Operation, dst *Event) error {
+	if op == h.veto {
+		return errFake
+	}
+	if h.change != nil {
+		h.change(op, dst)
+	}
+	return nil
+}
+
+type fakeMux map[string]internal.Provider
+
+func (m fakeMux) Get(platform string) (internal.Provider, error) {
+	p, ok := m[platform]
+	if !ok {
+		return nil, fmt.Errorf("provider %s not found", platform)
+	}
+	return p, nil
+}
+
+func testCalendar(account, name string) *Calendar {
+	return &Calendar{
+		ID:         "google/" + account + "/" + name,
+		Name:       name,
+		ProviderID: account + "@" + name,
+		Account:    internal.Account{Platform: "google", Name: account},
+	}
+}
+
+func newTestSyncer(storage Storage, provider internal.Provider) *Syncer {
+	return New(io.Discard, fakeMux{"google": provider}, storage)
+}
+
+func at(hour int) time.Time {
+	return time.Date(2026, 3, 2, hour, 0, 0, 0, time.UTC)
+}
+
+func testEvent(id, summary string, hour int) *Event {
+	return &Event{
+		ID:             id,
+		Summary:        summary,
+		StartsAt:       at(hour),
+		EndsAt:         at(hour + 1),
+		ResponseStatus: internal.Accepted,
+	}
+}
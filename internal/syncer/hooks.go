@@ -0,0 +1,113 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// ErrVetoed is returned when a hook didn't allow an operation.
+var ErrVetoed = errors.New("operation vetoed")
+
+// Hooks are called around the operations done on destination calendars,
+// src is the event received from the source calendar and dst the event
+// written on the destination calendar.
+//
+// Returning an error from a Before hook vetoes the operation, changes
+// done on dst are written on the destination calendar.
+//
+// Buffers get the link and source event of their mirror. Busy blocks merge
+// the events of all sources, they get a link with only the destination
+// and no source event. Events deleted by a forced sync get a source event
+// with only its id, and a link with only the destination when the link
+// that mirrored them isn't known.
+type Hooks interface {
+	BeforeCreate(_ context.Context, _ *Link, src, dst *Event) error
+	AfterCreate(_ context.Context, _ *Link, src, dst *Event)
+	BeforeUpdate(_ context.Context, _ *Link, src, dst *Event) error
+	AfterUpdate(_ context.Context, _ *Link, src, dst *Event)
+	BeforeDelete(_ context.Context, _ *Link, src, dst *Event) error
+	AfterDelete(_ context.Context, _ *Link, src, dst *Event)
+}
+
+// NopHooks can be embedded to implement only some of the hooks.
+type NopHooks struct{}
+
+func (NopHooks) BeforeCreate(context.Context, *Link, *Event, *Event) error { return nil }
+func (NopHooks) AfterCreate(context.Context, *Link, *Event, *Event)        {}
+func (NopHooks) BeforeUpdate(context.Context, *Link, *Event, *Event) error { return nil }
+func (NopHooks) AfterUpdate(context.Context, *Link, *Event, *Event)        {}
+func (NopHooks) BeforeDelete(context.Context, *Link, *Event, *Event) error { return nil }
+func (NopHooks) AfterDelete(context.Context, *Link, *Event, *Event)        {}
+
+// applyEvent runs the operation on the destination of the link, calling
+// the hooks around it.
+func (s Syncer) applyEvent(ctx context.Context, op internal.Operation, provider internal.Provider, link *Link, src, event *Event) error {
+	dst := link.Destination
+
+	if err := s.beforeHook(ctx, op, link, src, event); err != nil {
+		return err
+	}
+
+	var err error
+	switch op {
+	case internal.OperationCreate:
+		err = s.createEvent(ctx, provider, dst, link.Source, src.ID, event)
+	case internal.OperationUpdate:
+		err = s.updateEvent(ctx, provider, dst, s.previousMirror(ctx, provider, dst, event.ID), event)
+	case internal.OperationDelete:
+		err = s.deleteEvent(ctx, provider, dst, s.previousMirror(ctx, provider, dst, event.ID), event)
+	}
+	if err != nil {
+		return err
+	}
+
+	if op == internal.OperationDelete {
+		s.deleteBuffers(ctx, provider, link, src, event.ID)
+	} else {
+		s.saveMirror(ctx, provider, link, src, event)
+	}
+	s.afterHook(ctx, op, link, src, event)
+	return nil
+}
+
+// beforeHook calls the Before hook of the operation, ErrVetoed is returned
+// when the hook doesn't allow it.
+func (s Syncer) beforeHook(ctx context.Context, op internal.Operation, link *Link, src, event *Event) error {
+	if s.Hooks == nil {
+		return nil
+	}
+
+	var err error
+	switch op {
+	case internal.OperationCreate:
+		err = s.Hooks.BeforeCreate(ctx, link, src, event)
+	case internal.OperationUpdate:
+		err = s.Hooks.BeforeUpdate(ctx, link, src, event)
+	case internal.OperationDelete:
+		err = s.Hooks.BeforeDelete(ctx, link, src, event)
+	}
+	if err != nil {
+		logf(s.output, link.Destination, "Not allowed to %s event %q: %v", op, event.Summary, err)
+		return fmt.Errorf("%w: %v", ErrVetoed, err)
+	}
+	return nil
+}
+
+// afterHook calls the After hook of the operation.
+func (s Syncer) afterHook(ctx context.Context, op internal.Operation, link *Link, src, event *Event) {
+	if s.Hooks == nil {
+		return
+	}
+
+	switch op {
+	case internal.OperationCreate:
+		s.Hooks.AfterCreate(ctx, link, src, event)
+	case internal.OperationUpdate:
+		s.Hooks.AfterUpdate(ctx, link, src, event)
+	case internal.OperationDelete:
+		s.Hooks.AfterDelete(ctx, link, src, event)
+	}
+}
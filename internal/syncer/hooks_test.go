@@ -0,0 +1,98 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+func TestHooksVeto(t *testing.T) {
+	tests := []struct {
+		name  string
+		veto  internal.Operation
+		force bool
+		// mirrors is how many mirrors are left after the second sync.
+		mirrors int
+	}{
+		{"create", internal.OperationCreate, false, 0},
+		{"update", internal.OperationUpdate, false, 1},
+		{"delete", internal.OperationDelete, false, 1},
+		{"forced delete", internal.OperationDelete, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+			storage := newFakeStorage(&Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive})
+			provider := newFakeProvider()
+			provider.add(src, testEvent("s1", "Standup", 9))
+			s := newTestSyncer(storage, provider)
+			if tt.veto != internal.OperationCreate {
+				if _, err := s.Sync(ctx, nil, false, internal.Date{}); err != nil {
+					t.Fatalf("Sync() = %v", err)
+				}
+			}
+
+			switch {
+			case tt.force:
+			case tt.veto == internal.OperationDelete:
+				provider.add(src, &Event{ID: "s1", ResponseStatus: internal.Cancelled})
+			case tt.veto == internal.OperationUpdate:
+				provider.add(src, testEvent("s1", "Standup", 10))
+			}
+			s.Hooks = fakeHooks{veto: tt.veto}
+			report, err := s.Sync(ctx, nil, tt.force, internal.Date{})
+			if err != nil {
+				t.Fatalf("Sync() = %v", err)
+			}
+
+			if report.Failed() {
+				t.Errorf("Sync() failed: %v", reportErrors(report))
+			}
+			if report.Links[0].Vetoed != 1 || len(report.Links[0].Vetoes) != 1 {
+				t.Errorf("got %d vetoed, want 1", report.Links[0].Vetoed)
+			}
+			mirrors := provider.list(dst)
+			if len(mirrors) != tt.mirrors {
+				t.Fatalf("got %d mirror(s), want %d", len(mirrors), tt.mirrors)
+			}
+			if tt.veto == internal.OperationUpdate && !mirrors[0].StartsAt.Equal(at(9)) {
+				t.Errorf("mirror starts at %s, want it not updated", mirrors[0].StartsAt)
+			}
+			if len(storage.retries) != 0 {
+				t.Errorf("got %d retries, vetoed operations must not be retried", len(storage.retries))
+			}
+		})
+	}
+}
+
+func TestHooksChange(t *testing.T) {
+	ctx := context.Background()
+	src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+	storage := newFakeStorage(&Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive})
+	provider := newFakeProvider()
+	provider.add(src, testEvent("s1", "Standup", 9))
+	s := newTestSyncer(storage, provider)
+	s.Hooks = fakeHooks{change: func(op internal.Operation, dst *Event) {
+		dst.Summary = "Busy"
+		dst.ColorID = string(op)
+	}}
+
+	if _, err := s.Sync(ctx, nil, false, internal.Date{}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	mirrors := provider.list(dst)
+	if len(mirrors) != 1 || mirrors[0].Summary != "Busy" || mirrors[0].ColorID != "create" {
+		t.Fatalf("got mirrors %+v, want the one changed by the hook", mirrors)
+	}
+
+	provider.add(src, testEvent("s1", "Standup", 10))
+	if _, err := s.Sync(ctx, nil, false, internal.Date{}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	mirrors = provider.list(dst)
+	if len(mirrors) != 1 || mirrors[0].Summary != "Busy" || mirrors[0].ColorID != "update" || !mirrors[0].StartsAt.Equal(at(10)) {
+		t.Fatalf("got mirrors %+v, want the one updated and changed by the hook", mirrors)
+	}
+}
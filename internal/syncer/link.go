@@ -0,0 +1,157 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// Unlink removes from the destination what was mirrored through the link,
+// as if all source events were cancelled. The link itself is kept.
+func (s Syncer) Unlink(ctx context.Context, link *Link) (*LinkReport, error) {
+	dst, src := link.Destination, link.Source
+	report := newLinkReport(link)
+
+	dstProvider, err := s.mux.Get(dst.Account.Platform)
+	if err != nil {
+		return report, report.errorf(s.output, dst, "Unable to load destination provider: %v", err)
+	}
+
+	var srcEventIDs []string
+	if dst.Mode == internal.CalendarModeBusy {
+		busy, err := s.storage.BusySources(ctx, dst, src)
+		if err != nil {
+			return report, report.errorf(s.output, dst, "Unable to get busy time of %s: %v", src, err)
+		}
+		for _, m := range busy {
+			srcEventIDs = append(srcEventIDs, m.SrcEventID)
+		}
+	} else {
+		mappings, err := s.storage.EventMappings(ctx, dst, src)
+		if err != nil {
+			return report, report.errorf(s.output, dst, "Unable to get events mapped from %s: %v", src, err)
+		}
+		for _, m := range mappings {
+			if m.ParentID == "" {
+				srcEventIDs = append(srcEventIDs, m.SrcEventID)
+			}
+		}
+	}
+
+	var foundErr bool
+	for _, id := range srcEventIDs {
+		op, err := s.removeEvent(ctx, dstProvider, link, id, report)
+		if errors.Is(err, ErrSyncing) {
+			return report, err
+		}
+		report.add(id, op, err)
+		if err != nil {
+			foundErr = true
+		}
+	}
+	if dst.Mode == internal.CalendarModeBusy {
+		if err := s.syncBusyBlocks(ctx, dstProvider, dst, report); err != nil {
+			return report, err
+		}
+	}
+	if foundErr {
+		return report, ErrSyncing
+	}
+	return report, nil
+}
+
+// Rerender updates all events mirrored into dst from their source events,
+// e.g. after dst was renamed. The source events are taken from the latest
+// state saved, those not saved are read from the source calendar.
+func (s Syncer) Rerender(ctx context.Context, dst *Calendar) ([]*LinkReport, error) {
+	if dst.Mode == internal.CalendarModeBusy {
+		// Busy blocks don't show anything about the calendars.
+		return nil, nil
+	}
+	dstProvider, err := s.mux.Get(dst.Account.Platform)
+	if err != nil {
+		logf(s.output, dst, "Unable to load destination provider: %v", err)
+		return nil, ErrSyncing
+	}
+	links, err := s.storage.Links(ctx, dst)
+	if err != nil {
+		logf(s.output, dst, "Unable to get source calendars: %v", err)
+		return nil, ErrSyncing
+	}
+
+	var (
+		reports  []*LinkReport
+		foundErr bool
+	)
+	for _, link := range links {
+		report := newLinkReport(link)
+		reports = append(reports, report)
+		if err := s.rerenderLink(ctx, dstProvider, link, report); err != nil {
+			foundErr = true
+		}
+	}
+	if foundErr {
+		return reports, ErrSyncing
+	}
+	return reports, nil
+}
+
+func (s Syncer) rerenderLink(ctx context.Context, dstProvider internal.Provider, link *Link, report *LinkReport) error {
+	dst, src := link.Destination, link.Source
+	srcProvider, err := s.mux.Get(src.Account.Platform)
+	if err != nil {
+		return report.errorf(s.output, dst, "Unable to load source provider: %v", err)
+	}
+	mappings, err := s.storage.EventMappings(ctx, dst, src)
+	if err != nil {
+		return report.errorf(s.output, dst, "Unable to get events mapped from %s: %v", src, err)
+	}
+
+	var foundErr bool
+	for _, m := range mappings {
+		if m.ParentID != "" {
+			continue
+		}
+		event, err := s.sourceEvent(ctx, srcProvider, src, m.SrcEventID)
+		if err != nil {
+			report.errorf(s.output, dst, "Unable to get event %s from %s: %v", m.SrcEventID, src, err)
+			foundErr = true
+			continue
+		}
+		if event == nil {
+			// Deleted since the last sync.
+			event = &Event{ID: m.SrcEventID, ResponseStatus: internal.Cancelled}
+		}
+		op, err := s.syncEvent(ctx, dstProvider, link, event, report)
+		if errors.Is(err, ErrSyncing) || errors.Is(err, internal.ErrAuthExpired) {
+			return err
+		}
+		report.add(m.SrcEventID, op, err)
+		if err != nil {
+			foundErr = true
+		}
+	}
+	if foundErr {
+		return ErrSyncing
+	}
+	return nil
+}
+
+// sourceEvent returns the latest state saved of the source event, it's
+// read from the source calendar when not saved. Nil is returned if the
+// event doesn't exist anymore.
+func (s Syncer) sourceEvent(ctx context.Context, provider internal.Provider, src *Calendar, id string) (*Event, error) {
+	cached, err := s.storage.SourceEvent(ctx, src, id)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		return cached.Event, nil
+	}
+	event, err := provider.Event(ctx, src, id)
+	if event != nil {
+		s.cacheEvent(ctx, src, event)
+	}
+	return event, err
+}
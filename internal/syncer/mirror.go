@@ -0,0 +1,68 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// mirrorOrigin returns where the event was mirrored from when it was
+// created by us, otherwise nil is returned.
+func (s Syncer) mirrorOrigin(ctx context.Context, src *Calendar, event *Event) (*internal.Origin, error) {
+	if event.Origin != nil {
+		origin := *event.Origin
+		return &origin, nil
+	}
+	if event.ResponseStatus == internal.Cancelled {
+		// Cancelled events don't have any detail.
+		return nil, nil
+	}
+	return s.storage.MirrorOrigin(ctx, src, event.ID)
+}
+
+// renderMirror changes the source event into its mirror in the destination
+// of the link, origin is where the mirror says it comes from. Events
+// passed through or without origin keep their summary.
+func renderMirror(link *Link, origin *internal.Origin, event *Event) {
+	if origin != nil {
+		if !origin.PassedThrough {
+			event.Summary = mirrorSummary(link.Destination, rsvpSummary(link.Options.RSVP, event))
+		}
+		event.Origin = origin
+	}
+	presentRSVP(link.Options.RSVP, event)
+}
+
+func mirrorSummary(dst *Calendar, summary string) string {
+	return fmt.Sprintf("[%s] %s", dst.Name, summary)
+}
+
+func responseStatus(event *Event) internal.ResponseStatus {
+	if event.ResponseStatus == "" {
+		// Events without attendees.
+		return internal.Accepted
+	}
+	return event.ResponseStatus
+}
+
+// rsvpSummary returns the summary of the event with the marker of its
+// response status.
+func rsvpSummary(policy internal.RSVPPolicy, event *Event) string {
+	if marker := policy.Markers[responseStatus(event)]; marker != "" {
+		return marker + " " + event.Summary
+	}
+	return event.Summary
+}
+
+// presentRSVP changes how the mirror is shown based on the response to
+// the event.
+func presentRSVP(policy internal.RSVPPolicy, event *Event) {
+	status := responseStatus(event)
+	if color := policy.Colors[status]; color != "" {
+		event.ColorID = color
+	}
+	if policy.ShowAsFree && (status == internal.Declined || status == internal.NeedsAction) {
+		event.Transparent = true
+	}
+}
@@ -0,0 +1,282 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// ReconcileReport holds what was fixed on a destination calendar.
+type ReconcileReport struct {
+	Calendar        *Calendar
+	Recreated       int
+	Deleted         int
+	Orphans         int
+	DroppedMappings int
+	Failed          int
+}
+
+// Reconcile compares the events of the destination calendars with the
+// events mapped on the storage and the current state of the source
+// calendars. Missing mirrors are created again, mirrors without source
+// event or mapping are deleted and mappings pointing to events that
+// don't exist anymore are removed.
+func (s Syncer) Reconcile(ctx context.Context, calIDs []string) ([]*ReconcileReport, error) {
+	dstcals, err := s.storage.DestinationCalendars(ctx, calIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*ReconcileReport
+	for _, dstcal := range dstcals {
+		if err := ctx.Err(); err != nil {
+			return reports, err
+		}
+
+		report, err := s.reconcileCalendar(ctx, dstcal)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (s Syncer) reconcileCalendar(ctx context.Context, dst *Calendar) (*ReconcileReport, error) {
+	logf(s.output, dst, "Reconciling calendar...")
+
+	report := &ReconcileReport{Calendar: dst}
+	if dst.Account.NeedsReauth() {
+		logf(s.output, dst, "Skipping, account %s needs to authenticate again", dst.Account.ID())
+		return report, nil
+	}
+
+	dstProvider, err := s.mux.Get(dst.Account.Platform)
+	if err != nil {
+		logf(s.output, dst, "Unable to load destination provider: %v", err)
+		return nil, err
+	}
+	dstEvents, err := s.listEvents(ctx, dstProvider, dst, false)
+	if err != nil {
+		logf(s.output, dst, "Unable to get list of events: %v", err)
+		return nil, err
+	}
+
+	mappings, err := s.storage.EventMappings(ctx, dst, nil)
+	if err != nil {
+		logf(s.output, dst, "Unable to get events mapped: %v", err)
+		return nil, err
+	}
+	mapped := make(map[string]bool, len(mappings))
+	for _, m := range mappings {
+		mapped[m.EventID] = true
+	}
+
+	links, err := s.storage.Links(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	if dst.Mode == internal.CalendarModeBusy {
+		err := s.reconcileBusy(ctx, dstProvider, dst, links, dstEvents, report)
+		if err != nil {
+			return nil, err
+		}
+		logf(s.output, dst, "Reconcile complete: %d block(s) created, %d deleted, %d orphan(s) deleted, %d block(s) dropped, %d failed",
+			report.Recreated, report.Deleted, report.Orphans, report.DroppedMappings, report.Failed)
+		return report, nil
+	}
+	for _, link := range links {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		err := s.reconcileSource(ctx, dstProvider, link, dstEvents, mapped, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logf(s.output, dst, "Reconcile complete: %d recreated, %d deleted, %d orphan(s) deleted, %d mapping(s) dropped, %d failed",
+		report.Recreated, report.Deleted, report.Orphans, report.DroppedMappings, report.Failed)
+	return report, nil
+}
+
+// reconcileSource fixes the events mirrored through the link, dstEvents
+// are the events found in the destination and dstMapped the ids of all
+// events mapped in it.
+func (s Syncer) reconcileSource(ctx context.Context, dstProvider internal.Provider, link *Link, dstEvents map[string]*Event, dstMapped map[string]bool, report *ReconcileReport) error {
+	dst, src := link.Destination, link.Source
+	if src.Account.NeedsReauth() {
+		logf(s.output, dst, "Skipping %s, account %s needs to authenticate again", src, src.Account.ID())
+		return nil
+	}
+	srcProvider, err := s.mux.Get(src.Account.Platform)
+	if err != nil {
+		logf(s.output, dst, "Unable to load source provider: %v", err)
+		return err
+	}
+	srcEvents, err := s.listSourceEvents(ctx, srcProvider, src)
+	if err != nil {
+		logf(s.output, dst, "Unable to get list of events from %s: %v", src, err)
+		return err
+	}
+
+	mappings, err := s.storage.EventMappings(ctx, dst, src)
+	if err != nil {
+		logf(s.output, dst, "Unable to get events mapped from %s: %v", src, err)
+		return err
+	}
+
+	mapped := make(map[string]bool)
+	for _, m := range mappings {
+		if m.ParentID != "" {
+			// Buffers follow their mirror, only drop the ones that
+			// don't exist anymore.
+			if _, exists := dstEvents[m.EventID]; !exists {
+				err := s.storage.DeleteEvent(ctx, dst, m.EventID)
+				if err != nil {
+					report.Failed++
+					continue
+				}
+				report.DroppedMappings++
+			}
+			delete(dstEvents, m.EventID)
+			continue
+		}
+		srcEvent, wanted := srcEvents[m.SrcEventID]
+		wanted = wanted && srcEvent.ResponseStatus != internal.Cancelled && !s.ignoreEvent(link, srcEvent)
+		_, exists := dstEvents[m.EventID]
+
+		switch {
+		case wanted && exists:
+			mapped[m.SrcEventID] = true
+
+		case wanted:
+			logf(s.output, dst, "Event %s is missing, creating it again", m.EventID)
+
+			err := s.storage.DeleteEvent(ctx, dst, m.EventID)
+			if err == nil {
+				err = s.storage.DeleteEventSources(ctx, dst, m.EventID)
+			}
+			var op internal.Operation
+			if err == nil {
+				op, err = s.createMirror(ctx, dstProvider, link, srcEvent)
+			}
+			if err != nil && !errors.Is(err, ErrVetoed) {
+				report.Failed++
+				continue
+			}
+			mapped[m.SrcEventID] = true
+			if op == internal.OperationCreate && err == nil {
+				report.Recreated++
+			}
+
+		case exists:
+			op, err := s.removeEvent(ctx, dstProvider, link, m.SrcEventID, nil)
+			if err != nil {
+				report.Failed++
+				continue
+			}
+			if op != internal.OperationDelete {
+				// Another source still contributes to the event.
+				continue
+			}
+			report.Deleted++
+
+		default:
+			logf(s.output, dst, "Removing mapping of event %s, it doesn't exist anymore", m.EventID)
+
+			err := s.storage.DeleteEvent(ctx, dst, m.EventID)
+			if err != nil {
+				logf(s.output, dst, "Unable to delete event from storage %s: %v", m.EventID, err)
+				report.Failed++
+				continue
+			}
+			report.DroppedMappings++
+		}
+		delete(dstEvents, m.EventID)
+	}
+
+	// Source events that were never mirrored, e.g. creating them failed
+	// but the sync token was saved anyway.
+	for id, event := range srcEvents {
+		if mapped[id] || event.ResponseStatus == internal.Cancelled || s.ignoreEvent(link, event) {
+			continue
+		}
+		op, err := s.createMirror(ctx, dstProvider, link, event)
+		if err != nil && !errors.Is(err, ErrVetoed) {
+			report.Failed++
+			continue
+		}
+		if op == internal.OperationCreate && err == nil {
+			report.Recreated++
+		}
+	}
+
+	// Events created by us that aren't mapped, e.g. left behind when we
+	// weren't able to save the mapping.
+	for id, event := range dstEvents {
+		if event.Origin == nil || event.Origin.CalendarID != src.ID || dstMapped[id] {
+			continue
+		}
+		logf(s.output, dst, "Deleting orphan event %s: %q on %s", id, event.Summary, formatDateTime(event.StartsAt))
+
+		err := s.deleteOrphan(ctx, dstProvider, dst, event)
+		if err != nil {
+			report.Failed++
+			continue
+		}
+		delete(dstEvents, id)
+		report.Orphans++
+	}
+	return nil
+}
+
+// deleteOrphan deletes the event created by us that isn't mapped.
+func (s Syncer) deleteOrphan(ctx context.Context, provider internal.Provider, dst *Calendar, event *Event) error {
+	err := provider.DeleteEvent(ctx, dst, event.ID)
+	if err != nil {
+		logf(s.output, dst, "Unable to delete event from provider %s: %v", event.ID, err)
+		return err
+	}
+	s.audit(ctx, &internal.AuditEntry{
+		CalendarID: dst.ID,
+		EventID:    event.ID,
+		Kind:       internal.AuditEvent,
+		Operation:  internal.OperationDelete,
+		Before:     event,
+	}, nil)
+	return nil
+}
+
+// createMirror mirrors the source event into dst, which was never mirrored
+// or its mirror doesn't exist anymore. The operation done on dst is
+// returned, it's only a create when a new event was written in dst.
+func (s Syncer) createMirror(ctx context.Context, provider internal.Provider, link *Link, srcEvent *Event) (internal.Operation, error) {
+	event := *srcEvent
+	return s.syncEvent(ctx, provider, link, &event, nil)
+}
+
+// listEvents returns all events from cal indexed by their id.
+func (s Syncer) listEvents(ctx context.Context, provider internal.Provider, cal *Calendar, single bool) (map[string]*Event, error) {
+	var (
+		it  internal.Iterator
+		err error
+	)
+	if single {
+		it, err = provider.NewEventsFrom(ctx, cal, internal.Date{})
+	} else {
+		it, err = provider.Events(ctx, cal, internal.Date{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(map[string]*Event)
+	for it.Next() {
+		event := it.Event()
+		events[event.ID] = event
+	}
+	return events, it.Err()
+}
@@ -0,0 +1,71 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+	link := &Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive}
+	storage := newFakeStorage(link)
+	provider := newFakeProvider()
+	provider.add(src, testEvent("s1", "Standup", 9))
+	provider.add(src, testEvent("s2", "Review", 11))
+	s := newTestSyncer(storage, provider)
+
+	if _, err := s.SyncCalendar(ctx, link, internal.Date{}); err != nil {
+		t.Fatalf("SyncCalendar() = %v", err)
+	}
+	missing, _ := storage.DestinationEventID(ctx, dst, src, "s1")
+	kept, _ := storage.DestinationEventID(ctx, dst, src, "s2")
+
+	// The mirror of s1 was deleted by hand, an orphan was left behind and
+	// a mapping points to an event that doesn't exist anywhere.
+	delete(provider.events[dst.ID], missing)
+	orphan := testEvent("orphan", "[personal] Lunch", 12)
+	orphan.Origin = &internal.Origin{CalendarID: src.ID, EventID: "s3"}
+	provider.add(dst, orphan)
+	storage.SaveEvent(ctx, &internal.EventMapping{CalendarID: dst.ID, EventID: "stale", SrcCalendarID: src.ID, SrcEventID: "s4"})
+
+	reports, err := s.Reconcile(ctx, nil)
+	if err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d report(s), want 1", len(reports))
+	}
+	got := *reports[0]
+	want := ReconcileReport{Calendar: dst, Recreated: 1, Orphans: 1, DroppedMappings: 1}
+	if got != want {
+		t.Errorf("Reconcile() = %+v, want %+v", got, want)
+	}
+
+	recreated, _ := storage.DestinationEventID(ctx, dst, src, "s1")
+	if recreated == "" || recreated == missing || provider.events[dst.ID][recreated] == nil {
+		t.Errorf("mirror of s1 = %q, want a new event", recreated)
+	}
+	if provider.events[dst.ID][kept] == nil {
+		t.Errorf("mirror of s2 was deleted, want it kept")
+	}
+	if provider.events[dst.ID]["orphan"] != nil {
+		t.Errorf("orphan event was kept, want it deleted")
+	}
+	if m, _ := storage.EventMapping(ctx, dst, "stale"); m != nil {
+		t.Errorf("stale mapping was kept, want it dropped")
+	}
+
+	// Mirrors not created again aren't counted, even if s1 is wanted.
+	delete(provider.events[dst.ID], recreated)
+	s.Hooks = fakeHooks{veto: internal.OperationCreate}
+	reports, err = s.Reconcile(ctx, nil)
+	if err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if got, want := *reports[0], (ReconcileReport{Calendar: dst}); got != want {
+		t.Errorf("Reconcile() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+// SyncReport holds what happened with each link during a sync.
+type SyncReport struct {
+	// RunID is the id of the run in the history, when it's saved.
+	RunID     int64         `json:"run_id,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Links     []*LinkReport `json:"links"`
+}
+
+// Failed checks if any of the links failed, even partially.
+func (r *SyncReport) Failed() bool {
+	for _, l := range r.Links {
+		if l.Failed > 0 || len(l.Errors) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type LinkReport struct {
+	LinkID        int64         `json:"link_id"`
+	Source        string        `json:"source"`
+	Destination   string        `json:"destination"`
+	Created       int           `json:"created"`
+	Updated       int           `json:"updated"`
+	Deleted       int           `json:"deleted"`
+	Skipped       int           `json:"skipped"`
+	Failed        int           `json:"failed"`
+	Vetoed        int           `json:"vetoed"`
+	Duration      time.Duration `json:"duration"`
+	TokenAdvanced bool          `json:"token_advanced"`
+	Errors        []string      `json:"errors,omitempty"`
+	Vetoes        []string      `json:"vetoes,omitempty"`
+}
+
+// RunLinks returns the report of each link to be saved with the run.
+func (r *SyncReport) RunLinks() []*internal.RunLink {
+	res := make([]*internal.RunLink, len(r.Links))
+	for i, l := range r.Links {
+		res[i] = &internal.RunLink{
+			LinkID:        l.LinkID,
+			Source:        l.Source,
+			Destination:   l.Destination,
+			Created:       l.Created,
+			Updated:       l.Updated,
+			Deleted:       l.Deleted,
+			Skipped:       l.Skipped,
+			Failed:        l.Failed,
+			Vetoed:        l.Vetoed,
+			Duration:      l.Duration,
+			TokenAdvanced: l.TokenAdvanced,
+			Errors:        l.Errors,
+		}
+	}
+	return res
+}
+
+func newLinkReport(link *Link) *LinkReport {
+	return &LinkReport{
+		LinkID:      link.ID,
+		Source:      link.Source.String(),
+		Destination: link.Destination.String(),
+	}
+}
+
+// add counts the operation done for the source event.
+func (r *LinkReport) add(srcEventID string, op internal.Operation, err error) {
+	if r == nil {
+		return
+	}
+	if errors.Is(err, ErrVetoed) {
+		r.Vetoed++
+		r.Vetoes = append(r.Vetoes, fmt.Sprintf("%s event %s: %v", op, srcEventID, err))
+		return
+	}
+	if err != nil {
+		r.Failed++
+		r.Errors = append(r.Errors, fmt.Sprintf("%s event %s: %v", op, srcEventID, err))
+		return
+	}
+	switch op {
+	case internal.OperationCreate:
+		r.Created++
+	case internal.OperationUpdate:
+		r.Updated++
+	case internal.OperationDelete:
+		r.Deleted++
+	default:
+		r.Skipped++
+	}
+}
+
+// errorf logs the error and adds it to the report, ErrSyncing is
+// returned so it can be used as the error of the sync.
+func (r *LinkReport) errorf(w io.Writer, cal *Calendar, format string, a ...any) error {
+	logf(w, cal, format, a...)
+	if r != nil {
+		r.Errors = append(r.Errors, fmt.Sprintf(format, a...))
+	}
+	return ErrSyncing
+}
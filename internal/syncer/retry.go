@@ -0,0 +1,102 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+const (
+	defaultMaxAttempts = 5
+	retryBackoff       = 5 * time.Minute
+	maxRetryBackoff    = 24 * time.Hour
+)
+
+// retryBackoffAfter returns how long to wait before the next attempt.
+func retryBackoffAfter(attempts int) time.Duration {
+	d := retryBackoff
+	for i := 1; i < attempts && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryBackoff)
+}
+
+// queueRetry saves the failed operation so it's tried again on the next
+// syncs, allowing the sync token to move forward.
+func (s Syncer) queueRetry(ctx context.Context, dst, src *Calendar, op internal.Operation, event *Event, opErr error) error {
+	r, err := s.storage.Retry(ctx, dst, src, event.ID)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		r = &internal.Retry{
+			CalendarID:    dst.ID,
+			SrcCalendarID: src.ID,
+		}
+	}
+	r.Operation = op
+	r.Event = event
+	return s.failRetry(ctx, dst, r, opErr)
+}
+
+func (s Syncer) failRetry(ctx context.Context, dst *Calendar, r *internal.Retry, opErr error) error {
+	r.Attempts++
+	r.LastError = opErr.Error()
+	r.NextAttemptAt = time.Now().Add(retryBackoffAfter(r.Attempts))
+	r.Status = internal.RetryPending
+
+	var tErr *internal.TransientError
+	if errors.As(opErr, &tErr) && time.Until(r.NextAttemptAt) < tErr.RetryAfter {
+		r.NextAttemptAt = time.Now().Add(tErr.RetryAfter)
+	}
+
+	if internal.IsPermanent(opErr) {
+		logf(s.output, dst, "Giving up on event %s, it won't succeed if tried again: %v", r.Event.ID, opErr)
+		r.Status = internal.RetryDead
+	} else if r.Attempts >= s.MaxAttempts {
+		logf(s.output, dst, "Giving up on event %s after %d attempt(s): %v", r.Event.ID, r.Attempts, opErr)
+		r.Status = internal.RetryDead
+	} else {
+		logf(s.output, dst, "Event %s will be tried again after %s", r.Event.ID, formatDateTime(r.NextAttemptAt))
+	}
+	return write(ctx, func(ctx context.Context) error {
+		return s.storage.SaveRetry(ctx, r)
+	})
+}
+
+// retryEvents tries again the operations that failed previously and are
+// due.
+func (s Syncer) retryEvents(ctx context.Context, dstProvider internal.Provider, link *Link, report *LinkReport) error {
+	dst, src := link.Destination, link.Source
+	retries, err := s.storage.DueRetries(ctx, dst, src, time.Now())
+	if err != nil {
+		return report.errorf(s.output, dst, "Unable to get events to retry: %v", err)
+	}
+	for _, r := range retries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logf(s.output, dst, "Retrying %s of event %s, attempt %d", r.Operation, r.Event.ID, r.Attempts+1)
+
+		event := *r.Event
+		op, err := s.syncEvent(ctx, dstProvider, link, &event, report)
+		if errors.Is(err, ErrSyncing) {
+			return err
+		}
+		if errors.Is(err, internal.ErrAuthExpired) {
+			return report.errorf(s.output, dst, "Stopping sync with %s: %v", src, err)
+		}
+		report.add(r.Event.ID, op, err)
+		if err == nil || errors.Is(err, ErrVetoed) {
+			err = s.storage.DeleteRetry(ctx, dst, src, r.Event.ID)
+		} else {
+			err = s.failRetry(ctx, dst, r, err)
+		}
+		if err != nil {
+			logf(s.output, dst, "Unable to save retry of event %s: %v", r.Event.ID, err)
+		}
+	}
+	return nil
+}
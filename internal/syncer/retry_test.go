@@ -0,0 +1,26 @@
+package syncer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoffAfter(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{9, 1280 * time.Minute},
+		{10, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryBackoffAfter(tt.attempts); got != tt.want {
+			t.Errorf("retryBackoffAfter(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package syncer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/guilherme-santos/synccalendar/internal"
 )
@@ -14,17 +16,61 @@ var ErrSyncing = errors.New("an error occoured while syncing, check the logs")
 type (
 	Mux      = internal.Mux
 	Calendar = internal.Calendar
+	Link     = internal.Link
 	Event    = internal.Event
 )
 
 type Storage interface {
 	DestinationCalendars(_ context.Context, calIDs []string) ([]*Calendar, error)
-	SourceCalendars(_ context.Context, dstCalID string) ([]*Calendar, error)
-
-	DestinationEventID(_ context.Context, _ *Calendar, srcEventID string) (string, error)
-	CreateEvent(_ context.Context, _ *Calendar, dstEventID, srcEventID string) error
+	Links(_ context.Context, dst *Calendar) ([]*Link, error)
+
+	DestinationEventID(_ context.Context, dst, src *Calendar, srcEventID string) (string, error)
+	UnclaimedEventID(_ context.Context, dst *Calendar, srcEventID string) (string, error)
+	ClaimEvent(_ context.Context, dst, src *Calendar, dstEventID string) error
+	EventMappings(_ context.Context, dst, src *Calendar) ([]*internal.EventMapping, error)
+	ClaimEvents(_ context.Context, dst, src *Calendar) error
+	CreateEvent(_ context.Context, dst, src *Calendar, dstEventID, srcEventID string) error
+	SaveEvent(context.Context, *internal.EventMapping) error
+	ChildEvents(_ context.Context, _ *Calendar, eventID string) ([]*internal.EventMapping, error)
+	Overlaps(_ context.Context, _ *Calendar, startsAt, endsAt time.Time, exclude ...string) (bool, error)
 	DeleteEvent(_ context.Context, _ *Calendar, eventID string) error
-	SaveLastSync(_ context.Context, _ *Calendar, lastSync string) error
+	SaveLastSync(_ context.Context, _ *Link, lastSync string) error
+
+	Retry(_ context.Context, dst, src *Calendar, srcEventID string) (*internal.Retry, error)
+	DueRetries(_ context.Context, dst, src *Calendar, now time.Time) ([]*internal.Retry, error)
+	SaveRetry(context.Context, *internal.Retry) error
+	DeleteRetry(_ context.Context, dst, src *Calendar, srcEventID string) error
+
+	EventSourceID(_ context.Context, dst, src *Calendar, srcEventID string) (string, error)
+	DedupEventID(_ context.Context, dst *Calendar, key string) (string, error)
+	EventSources(_ context.Context, dst *Calendar, dstEventID string) ([]*internal.EventMapping, error)
+	SaveEventSource(_ context.Context, _ *internal.EventMapping, key string, active bool) error
+	DeleteEventSources(_ context.Context, dst *Calendar, dstEventID string) error
+	ReassignEvent(context.Context, *internal.EventMapping) error
+
+	MirrorOrigin(_ context.Context, _ *Calendar, eventID string) (*internal.Origin, error)
+
+	BusySources(_ context.Context, dst, src *Calendar) ([]*internal.EventMapping, error)
+	SaveBusySource(context.Context, *internal.EventMapping) error
+	DeleteBusySource(_ context.Context, dst, src *Calendar, srcEventID string) error
+	BusyBlocks(_ context.Context, dst *Calendar) ([]*internal.BusyBlock, error)
+	SaveBusyBlock(context.Context, *internal.BusyBlock) error
+	DeleteBusyBlock(_ context.Context, dst *Calendar, eventID string) error
+
+	SaveSourceEvent(_ context.Context, src *Calendar, _ *Event) error
+	SourceEvent(_ context.Context, src *Calendar, eventID string) (*internal.SourceEvent, error)
+	DeleteSourceEventsBefore(_ context.Context, src *Calendar, _ time.Time) error
+
+	EventMapping(_ context.Context, _ *Calendar, eventID string) (*internal.EventMapping, error)
+	SaveMirror(context.Context, *Calendar, *Event) error
+	Mirror(_ context.Context, _ *Calendar, eventID string) (*Event, error)
+	SaveAudit(context.Context, *internal.AuditEntry) error
+	AuditEntries(_ context.Context, runID int64) ([]*internal.AuditEntry, error)
+	SetAuditUndone(context.Context, *internal.AuditEntry) error
+
+	// Batch calls fn with a context whose writes are saved in a single
+	// transaction.
+	Batch(_ context.Context, fn func(context.Context) error) error
 }
 
 type Syncer struct {
@@ -36,174 +82,531 @@ type Syncer struct {
 	IgnoreMyEventsAlone    bool
 	IgnoreOutOfOfficeEvent bool
 	IgnoreFocusTimeEvent   bool
+
+	// MaxAttempts is how many times a failed operation is tried before
+	// giving up on it.
+	MaxAttempts int
+	// Hooks if set are called around each operation on the destination
+	// calendars.
+	Hooks Hooks
+	// RunID is saved with every operation done on the destination
+	// calendars, so the run can be undone.
+	RunID int64
 }
 
 func New(output io.Writer, providers Mux, storage Storage) *Syncer {
 	if output == nil {
-		output = os.Stdout
+		output = os.Stderr
 	}
 	return &Syncer{
-		output:  output,
-		mux:     providers,
-		storage: storage,
+		output:      output,
+		mux:         providers,
+		storage:     storage,
+		MaxAttempts: defaultMaxAttempts,
 	}
 }
 
-func (s Syncer) Sync(ctx context.Context, calIDs []string, force bool, forceFrom internal.Date) error {
+// Sync mirrors the events of all links into their destination, what
+// happened with each link is returned in the report. When forced, the
+// events deleted from each destination are reported without link.
+func (s Syncer) Sync(ctx context.Context, calIDs []string, force bool, forceFrom internal.Date) (*SyncReport, error) {
+	report := &SyncReport{StartedAt: time.Now()}
+	defer func() {
+		report.Duration = time.Since(report.StartedAt)
+	}()
+
 	dstcals, err := s.storage.DestinationCalendars(ctx, calIDs)
 	if err != nil {
-		return err
+		return report, err
 	}
+	warned := make(map[string]bool)
 	for _, dstcal := range dstcals {
 		if err := ctx.Err(); err != nil {
-			return err
+			return report, err
 		}
 
-		if force {
-			err := s.DeleteEvents(ctx, dstcal, forceFrom)
+		if force && !dstcal.Account.NeedsReauth() {
+			deleteReport, err := s.DeleteEvents(ctx, dstcal, forceFrom)
+			report.Links = append(report.Links, deleteReport)
 			if err != nil {
-				return err
+				return report, err
 			}
 		}
 
-		srccals, err := s.storage.SourceCalendars(ctx, dstcal.ID)
+		links, err := s.storage.Links(ctx, dstcal)
 		if err != nil {
-			return err
+			return report, err
 		}
-		for _, srccal := range srccals {
+		for _, link := range links {
 			if err := ctx.Err(); err != nil {
-				return err
+				return report, err
+			}
+			if link.Status == internal.LinkPaused {
+				logf(s.output, dstcal, "Skipping %s, it's paused", link.Source)
+				continue
+			}
+			if acc := reauthAccount(link); acc != nil {
+				linkReport := newLinkReport(link)
+				msg := fmt.Sprintf("Skipping %s, account %s needs to authenticate again", link.Source, acc.ID())
+				if !warned[acc.ID()] {
+					warned[acc.ID()] = true
+					logf(s.output, dstcal, "%s: %s", msg, acc.AuthError)
+				}
+				linkReport.Errors = append(linkReport.Errors, msg)
+				report.Links = append(report.Links, linkReport)
+				continue
 			}
 
-			err := s.SyncCalendar(ctx, dstcal, srccal, forceFrom)
+			linkReport, err := s.SyncCalendar(ctx, link, forceFrom)
+			report.Links = append(report.Links, linkReport)
 			if err != nil && !errors.Is(err, ErrSyncing) {
-				return err
+				return report, err
 			}
 		}
 	}
+	return report, nil
+}
+
+// reauthAccount returns the account of the link that needs to
+// authenticate again, if any.
+func reauthAccount(link *Link) *internal.Account {
+	switch {
+	case link.Source.Account.NeedsReauth():
+		return &link.Source.Account
+	case link.Destination.Account.NeedsReauth():
+		return &link.Destination.Account
+	}
 	return nil
 }
 
-func (s Syncer) DeleteEvents(ctx context.Context, cal *Calendar, from internal.Date) error {
+// DeleteEvents deletes the events created by us on cal, events that are
+// mapped on the storage or carry our origin. Any other event is kept.
+// What was deleted is returned in a report without link, as the events
+// can come from any source of cal.
+func (s Syncer) DeleteEvents(ctx context.Context, cal *Calendar, from internal.Date) (*LinkReport, error) {
 	logf(s.output, cal, "Removing events since: %s", relativeDate(from))
 
+	report := &LinkReport{Destination: cal.String()}
+	defer func(start time.Time) {
+		report.Duration = time.Since(start)
+	}(time.Now())
+
 	provider, err := s.mux.Get(cal.Account.Platform)
 	if err != nil {
-		logf(s.output, cal, "Unable to load provider: %v", err)
-		return ErrSyncing
+		return report, report.errorf(s.output, cal, "Unable to load provider: %v", err)
+	}
+
+	mappings, err := s.storage.EventMappings(ctx, cal, nil)
+	if err != nil {
+		return report, report.errorf(s.output, cal, "Unable to get events mapped: %v", err)
+	}
+	mapped := make(map[string]*internal.EventMapping, len(mappings))
+	for _, m := range mappings {
+		mapped[m.EventID] = m
+	}
+	blocks, err := s.storage.BusyBlocks(ctx, cal)
+	if err != nil {
+		return report, report.errorf(s.output, cal, "Unable to get busy blocks: %v", err)
+	}
+	busy := make(map[string]bool, len(blocks))
+	for _, b := range blocks {
+		busy[b.EventID] = true
+	}
+	links, err := s.storage.Links(ctx, cal)
+	if err != nil {
+		return report, report.errorf(s.output, cal, "Unable to get source calendars: %v", err)
+	}
+	sources := make(map[string]*Link, len(links))
+	for _, link := range links {
+		sources[link.Source.ID] = link
 	}
 
 	it, err := provider.Events(ctx, cal, from)
 	if err != nil {
-		logf(s.output, cal, "Unable to get list of events: %v", err)
-		return ErrSyncing
+		return report, report.errorf(s.output, cal, "Unable to get list of events: %v", err)
 	}
-	var (
-		eventsDeleted uint64
-		foundErr      bool
-	)
 	for it.Next() {
-		err := s.deleteEvent(ctx, provider, cal, it.Event())
+		event := it.Event()
+		if busy[event.ID] {
+			err := s.deleteBusyBlock(ctx, provider, cal, event)
+			report.add(event.ID, internal.OperationDelete, err)
+			continue
+		}
+
+		m := mapped[event.ID]
+		if m == nil && (event.Origin == nil || sources[event.Origin.CalendarID] == nil) {
+			logf(s.output, cal, "Keeping event %s: %q on %s, it wasn't created by us", event.ID, event.Summary, formatDateTime(event.StartsAt))
+			report.add(event.ID, "", nil)
+			continue
+		}
+
+		// The hooks get the link and source event of the mirror when known.
+		link := &Link{Destination: cal}
+		var src *Event
+		if m != nil {
+			src = &Event{ID: m.SrcEventID}
+			if l := sources[m.SrcCalendarID]; l != nil {
+				link = l
+			}
+		} else {
+			link, src = sources[event.Origin.CalendarID], &Event{ID: event.Origin.EventID}
+		}
+		if err := s.beforeHook(ctx, internal.OperationDelete, link, src, event); err != nil {
+			report.add(event.ID, internal.OperationDelete, err)
+			continue
+		}
+		err := s.deleteEvent(ctx, provider, cal, event, event)
+		if err == nil && cal.Mode == internal.CalendarModeDedup {
+			// The sources would still point to the deleted event.
+			err = s.storage.DeleteEventSources(ctx, cal, event.ID)
+			if err != nil {
+				logf(s.output, cal, "Unable to delete sources of event from storage %s: %v", event.ID, err)
+			}
+		}
+		report.add(event.ID, internal.OperationDelete, err)
 		if err != nil {
-			foundErr = true
 			continue
 		}
-		eventsDeleted++
+		s.afterHook(ctx, internal.OperationDelete, link, src, event)
 	}
 
 	if err := it.Err(); err != nil {
-		logf(s.output, cal, "Unable to get list of events: %v", err)
-		return ErrSyncing
+		return report, report.errorf(s.output, cal, "Unable to get list of events: %v", err)
 	}
-	if foundErr {
-		logf(s.output, cal, "Some events couldn't be deleted, %d deleted succesfully", eventsDeleted)
-	} else if eventsDeleted == 0 {
+	if report.Failed > 0 {
+		logf(s.output, cal, "Some events couldn't be deleted, %d deleted succesfully", report.Deleted)
+	} else if report.Deleted == 0 {
 		logf(s.output, cal, "No events found to be deleted")
 	} else {
-		logf(s.output, cal, "%d event(s) deleted succesfully", eventsDeleted)
+		logf(s.output, cal, "%d event(s) deleted succesfully", report.Deleted)
 	}
-	return nil
+	if report.Vetoed > 0 {
+		logf(s.output, cal, "%d event(s) were kept, the hooks didn't allow deleting them", report.Vetoed)
+	}
+	if report.Skipped > 0 {
+		logf(s.output, cal, "%d event(s) not created by us were kept", report.Skipped)
+	}
+	return report, nil
 }
 
-func (s Syncer) SyncCalendar(ctx context.Context, dst, src *Calendar, from internal.Date) error {
+func (s Syncer) SyncCalendar(ctx context.Context, link *Link, from internal.Date) (*LinkReport, error) {
+	dst, src := link.Destination, link.Source
 	logf(s.output, dst, "Syncing calendar with %s...", src)
 
+	report := newLinkReport(link)
+	defer func(start time.Time) {
+		report.Duration = time.Since(start)
+	}(time.Now())
+
 	dstProvider, err := s.mux.Get(dst.Account.Platform)
 	if err != nil {
-		logf(s.output, dst, "Unable to load destination provider: %v", err)
-		return ErrSyncing
+		return report, report.errorf(s.output, dst, "Unable to load destination provider: %v", err)
 	}
 	srcProvider, err := s.mux.Get(src.Account.Platform)
 	if err != nil {
-		logf(s.output, dst, "Unable to load source provider: %v", err)
-		return ErrSyncing
+		return report, report.errorf(s.output, dst, "Unable to load source provider: %v", err)
 	}
 
 	var it internal.Iterator
-	if !from.IsZero() || src.LastSync == "" {
+	if !from.IsZero() || link.LastSync == "" {
 		it, err = srcProvider.NewEventsFrom(ctx, src, from)
 	} else {
-		it, err = srcProvider.NewEventsSince(ctx, src, src.LastSync)
+		it, err = srcProvider.NewEventsSince(ctx, src, link.LastSync)
 	}
 	if err != nil {
-		logf(s.output, dst, "Unable to get new events from %s: %v", src, err)
-		return ErrSyncing
+		return report, report.errorf(s.output, dst, "Unable to get new events from %s: %v", src, err)
 	}
-	var foundErr bool
-	for it.Next() {
-		event := it.Event()
-		ignoreEvent := s.ignoreEvent(event)
-		srcProviderID := event.ID
+	if err := s.retryEvents(ctx, dstProvider, link, report); err != nil {
+		return report, err
+	}
+	foundErr, err := s.syncEvents(ctx, dstProvider, link, it, nil, report)
+	if errors.Is(err, internal.ErrInvalidSyncToken) {
+		logf(s.output, dst, "Sync token of %s is no longer valid, running a full sync", src)
+		it, foundErr, err = s.fullSync(ctx, dstProvider, srcProvider, link, report)
+	}
+	if err != nil {
+		return report, err
+	}
+	if dst.Mode == internal.CalendarModeBusy {
+		if err := s.syncBusyBlocks(ctx, dstProvider, dst, report); err != nil {
+			return report, err
+		}
+	}
+
+	if foundErr {
+		logf(s.output, dst, "Sync complete with error!")
+	} else {
+		if lastSync := it.LastSync(); lastSync != "" {
+			err = s.storage.SaveLastSync(ctx, link, lastSync)
+			if err != nil {
+				report.errorf(s.output, dst, "Unable to save last sync: %v", err)
+			} else {
+				report.TokenAdvanced = lastSync != link.LastSync
+			}
+		}
+		logf(s.output, dst, "Sync complete!")
+	}
+	return report, nil
+}
+
+// fullSync lists all events from src and reconciles them with the events
+// mirrored previously, mirrors whose source event doesn't exist anymore
+// are deleted.
+func (s Syncer) fullSync(ctx context.Context, dstProvider, srcProvider internal.Provider, link *Link, report *LinkReport) (internal.Iterator, bool, error) {
+	dst, src := link.Destination, link.Source
+	start := time.Now()
+	it, err := srcProvider.NewEventsFrom(ctx, src, internal.Date{})
+	if err != nil {
+		return nil, false, report.errorf(s.output, dst, "Unable to get events from %s: %v", src, err)
+	}
+	seen := make(map[string]bool)
+	foundErr, err := s.syncEvents(ctx, dstProvider, link, it, seen, report)
+	if err != nil {
+		if !errors.Is(err, ErrSyncing) {
+			report.errorf(s.output, dst, "Unable to get list of events: %v", err)
+		}
+		return nil, false, ErrSyncing
+	}
+	s.pruneEvents(ctx, src, start)
+
+	// Events mapped before their source calendar was saved can only come
+	// from src when it's the only source of dst.
+	links, err := s.storage.Links(ctx, dst)
+	if err != nil {
+		return nil, false, report.errorf(s.output, dst, "Unable to get links: %v", err)
+	}
+	if len(links) == 1 {
+		if err := s.storage.ClaimEvents(ctx, dst, src); err != nil {
+			return nil, false, report.errorf(s.output, dst, "Unable to assign events mapped to %s: %v", src, err)
+		}
+	}
+
+	mappings, err := s.storage.EventMappings(ctx, dst, src)
+	if err != nil {
+		return nil, false, report.errorf(s.output, dst, "Unable to get events mapped from %s: %v", src, err)
+	}
+	for _, m := range mappings {
+		if seen[m.SrcEventID] || m.ParentID != "" {
+			continue
+		}
+		logf(s.output, dst, "Event %s doesn't exist anymore on %s", m.SrcEventID, src)
 
-		// We don't care about the id from the source, but the id
-		// from the destination.
-		event.ID, err = s.storage.DestinationEventID(ctx, dst, srcProviderID)
+		op, err := s.removeEvent(ctx, dstProvider, link, m.SrcEventID, report)
+		if errors.Is(err, ErrSyncing) {
+			return nil, false, err
+		}
+		report.add(m.SrcEventID, op, err)
 		if err != nil {
-			logf(s.output, dst, "Unable to get destination event id %s: %v", event.ID, err)
-			return ErrSyncing
+			foundErr = true
 		}
+	}
 
-		if event.ResponseStatus == internal.Cancelled || ignoreEvent {
-			if event.ID != "" {
-				s.deleteEvent(ctx, dstProvider, dst, event)
-			}
-		} else if event.ID == "" {
-			err = s.createEvent(ctx, dstProvider, dst, srcProviderID, event)
+	busy, err := s.storage.BusySources(ctx, dst, src)
+	if err != nil {
+		return nil, false, report.errorf(s.output, dst, "Unable to get busy time of %s: %v", src, err)
+	}
+	for _, m := range busy {
+		if seen[m.SrcEventID] {
+			continue
+		}
+		err := s.storage.DeleteBusySource(ctx, dst, src, m.SrcEventID)
+		if err != nil {
+			return nil, false, report.errorf(s.output, dst, "Unable to delete busy time of event %s: %v", m.SrcEventID, err)
+		}
+	}
+	return it, foundErr, nil
+}
+
+// syncEvents mirrors all events from it into dst. When seen is not nil
+// the id of every source event is added to it.
+func (s Syncer) syncEvents(ctx context.Context, dstProvider internal.Provider, link *Link, it internal.Iterator, seen map[string]bool, report *LinkReport) (foundErr bool, _ error) {
+	dst, src := link.Destination, link.Source
+
+	ctx, page := withBatch(ctx)
+	flushPage := func() {
+		if err := s.flush(ctx, page); err != nil {
+			report.errorf(s.output, dst, "Unable to save events received from %s: %v", src, err)
+			foundErr = true
+		}
+	}
+	defer flushPage()
+	pages, _ := it.(internal.PageIterator)
+
+	for it.Next() {
+		event := it.Event()
+		if seen != nil {
+			seen[event.ID] = true
+		}
+		received := *event
+		s.cacheEvent(ctx, src, &received)
+
+		op, err := s.syncEvent(ctx, dstProvider, link, event, report)
+		if errors.Is(err, ErrSyncing) {
+			return false, err
+		}
+		if errors.Is(err, internal.ErrAuthExpired) {
+			return false, report.errorf(s.output, dst, "Stopping sync with %s: %v", src, err)
+		}
+		report.add(received.ID, op, err)
+		if err != nil && !errors.Is(err, ErrVetoed) {
+			err = s.queueRetry(ctx, dst, src, op, &received, err)
 		} else {
-			err = s.updateEvent(ctx, dstProvider, dst, event)
+			err = write(ctx, func(ctx context.Context) error {
+				return s.storage.DeleteRetry(ctx, dst, src, received.ID)
+			})
 		}
 		if err != nil {
+			logf(s.output, dst, "Unable to save retry of event %s: %v", received.ID, err)
 			foundErr = true
 		}
+		if pages != nil && pages.EndOfPage() {
+			flushPage()
+		}
 	}
 
 	if err := it.Err(); err != nil {
-		logf(s.output, dst, "Unable to get list of events: %v", err)
-		return ErrSyncing
+		if errors.Is(err, internal.ErrInvalidSyncToken) {
+			return false, err
+		}
+		return false, report.errorf(s.output, dst, "Unable to get list of events: %v", err)
 	}
-	if foundErr {
-		logf(s.output, dst, "Sync complete with error!")
-	} else {
-		if lastSync := it.LastSync(); lastSync != "" {
-			err = s.storage.SaveLastSync(ctx, src, lastSync)
-			if err != nil {
-				logf(s.output, dst, "Unable to save last sync: %v", err)
-			}
+	return foundErr, nil
+}
+
+// syncEvent mirrors the event into dst, the operation done on dst is
+// returned. The report can be nil.
+func (s Syncer) syncEvent(ctx context.Context, dstProvider internal.Provider, link *Link, event *Event, report *LinkReport) (internal.Operation, error) {
+	dst, src := link.Destination, link.Source
+	ignoreEvent := s.ignoreEvent(link, event)
+	srcProviderID := event.ID
+	srcEvent := *event
+
+	origin, err := s.mirrorOrigin(ctx, src, event)
+	if err != nil {
+		return "", report.errorf(s.output, dst, "Unable to check if event %s was created by us: %v", srcProviderID, err)
+	}
+	switch {
+	case origin == nil:
+		origin = &internal.Origin{
+			CalendarID: src.ID,
+			EventID:    srcProviderID,
 		}
-		logf(s.output, dst, "Sync complete!")
+	case link.Options.Mirrors == internal.MirrorsPassThrough && !origin.PassedThrough:
+		// Keep the summary and where it came from, so the next calendar
+		// doesn't mirror it again.
+		origin.PassedThrough = true
+	default:
+		if !ignoreEvent {
+			logf(s.output, dst, "Skipping event %s: %q, it was created by us", srcProviderID, event.Summary)
+		}
+		ignoreEvent = true
+		origin = nil
 	}
-	return nil
+	renderMirror(link, origin, event)
+
+	switch dst.Mode {
+	case internal.CalendarModeDedup:
+		return s.syncDedupEvent(ctx, dstProvider, link, &srcEvent, event, ignoreEvent, report)
+	case internal.CalendarModeBusy:
+		return s.syncBusyEvent(ctx, link, srcProviderID, event, ignoreEvent, report)
+	}
+
+	// We don't care about the id from the source, but the id
+	// from the destination.
+	event.ID, err = s.destinationEventID(ctx, link, srcProviderID)
+	if err != nil {
+		return "", report.errorf(s.output, dst, "Unable to get destination event id %s: %v", srcProviderID, err)
+	}
+
+	if event.ResponseStatus == internal.Cancelled || ignoreEvent {
+		if event.ID == "" {
+			return "", nil
+		}
+		return internal.OperationDelete, s.applyEvent(ctx, internal.OperationDelete, dstProvider, link, &srcEvent, event)
+	}
+	if event.ID == "" {
+		return internal.OperationCreate, s.applyEvent(ctx, internal.OperationCreate, dstProvider, link, &srcEvent, event)
+	}
+	return internal.OperationUpdate, s.applyEvent(ctx, internal.OperationUpdate, dstProvider, link, &srcEvent, event)
+}
+
+// destinationEventID returns the id of the event in the destination that
+// mirrors the source event. An event mapped before its source calendar was
+// saved is assigned to the link, unless another source of the destination
+// has the same event without mirroring it, then it's not known which one
+// it came from.
+func (s Syncer) destinationEventID(ctx context.Context, link *Link, srcEventID string) (string, error) {
+	dst, src := link.Destination, link.Source
+	id, err := s.storage.DestinationEventID(ctx, dst, src, srcEventID)
+	if err != nil || id != "" {
+		return id, err
+	}
+	id, err = s.storage.UnclaimedEventID(ctx, dst, srcEventID)
+	if err != nil || id == "" {
+		return id, err
+	}
+
+	links, err := s.storage.Links(ctx, dst)
+	if err != nil {
+		return "", err
+	}
+	for _, other := range links {
+		if other.Source.ID == src.ID {
+			continue
+		}
+		mirrored, err := s.storage.DestinationEventID(ctx, dst, other.Source, srcEventID)
+		if err != nil {
+			return "", err
+		}
+		if mirrored != "" {
+			continue
+		}
+		provider, err := s.mux.Get(other.Source.Account.Platform)
+		if err != nil {
+			return "", err
+		}
+		event, err := s.sourceEvent(ctx, provider, other.Source, srcEventID)
+		if err != nil {
+			return "", err
+		}
+		if event != nil && event.ResponseStatus != internal.Cancelled {
+			logf(s.output, dst, "Event %s might come from %s as well, not assigning it to %s", id, other.Source, src)
+			return "", nil
+		}
+	}
+	return id, s.storage.ClaimEvent(ctx, dst, src, id)
+}
+
+// removeEvent handles the source event as cancelled.
+func (s Syncer) removeEvent(ctx context.Context, dstProvider internal.Provider, link *Link, srcEventID string, report *LinkReport) (internal.Operation, error) {
+	return s.syncEvent(ctx, dstProvider, link, &Event{
+		ID:             srcEventID,
+		ResponseStatus: internal.Cancelled,
+	}, report)
 }
 
-func (s Syncer) deleteEvent(ctx context.Context, provider internal.Provider, cal *Calendar, event *Event) error {
+// deleteEvent deletes the event from cal, before is how it was in cal
+// and is saved to undo the operation.
+func (s Syncer) deleteEvent(ctx context.Context, provider internal.Provider, cal *Calendar, before, event *Event) error {
 	logf(s.output, cal, "Deleting event %s: %q on %s", event.ID, event.Summary, formatDateTime(event.StartsAt))
 
+	m := s.mapping(ctx, cal, event.ID)
 	err := provider.DeleteEvent(ctx, cal, event.ID)
 	if err != nil {
 		logf(s.output, cal, "Unable to delete event from provider %s: %v", event.ID, err)
 		return err
 	}
-	err = s.storage.DeleteEvent(ctx, cal, event.ID)
+	s.audit(ctx, &internal.AuditEntry{
+		CalendarID: cal.ID,
+		EventID:    event.ID,
+		Kind:       internal.AuditEvent,
+		Operation:  internal.OperationDelete,
+		Before:     before,
+	}, m)
+
+	err = write(ctx, func(ctx context.Context) error {
+		return s.storage.DeleteEvent(ctx, cal, event.ID)
+	})
 	if err != nil {
 		logf(s.output, cal, "Unable to delete event from storage %s: %v", event.ID, err)
 		return err
@@ -211,7 +614,7 @@ func (s Syncer) deleteEvent(ctx context.Context, provider internal.Provider, cal
 	return nil
 }
 
-func (s Syncer) createEvent(ctx context.Context, provider internal.Provider, cal *Calendar, srcProviderID string, event *Event) error {
+func (s Syncer) createEvent(ctx context.Context, provider internal.Provider, cal, src *Calendar, srcProviderID string, event *Event) error {
 	logf(s.output, cal, "Creating event: %q on %s", event.Summary, formatDateTime(event.StartsAt))
 
 	newEvent, err := provider.CreateEvent(ctx, cal, event)
@@ -221,19 +624,40 @@ func (s Syncer) createEvent(ctx context.Context, provider internal.Provider, cal
 	}
 	logf(s.output, cal, "Map event id %s to %s", srcProviderID, newEvent.ID)
 
-	err = s.storage.CreateEvent(ctx, cal, newEvent.ID, srcProviderID)
+	err = write(ctx, func(ctx context.Context) error {
+		err := s.storage.CreateEvent(ctx, cal, src, newEvent.ID, srcProviderID)
+		if err != nil {
+			return err
+		}
+		return s.storage.SaveMirror(ctx, cal, newEvent)
+	})
 	if err != nil {
 		logf(s.output, cal, "Unable to create event on the storage: %v", err)
 
 		// Let's try to remove from the provider as we couldn't save on our db
 		// this avoid that the next time we duplicate the event in the provider.
-		_ = provider.DeleteEvent(ctx, cal, event.ID)
+		_ = provider.DeleteEvent(ctx, cal, newEvent.ID)
 		return err
 	}
+	onFailure(ctx, func(ctx context.Context) {
+		logf(s.output, cal, "Deleting event %s, its mapping couldn't be saved", newEvent.ID)
+		_ = provider.DeleteEvent(ctx, cal, newEvent.ID)
+	})
+	event.ID = newEvent.ID
+
+	s.audit(ctx, &internal.AuditEntry{
+		CalendarID: cal.ID,
+		EventID:    newEvent.ID,
+		Kind:       internal.AuditEvent,
+		Operation:  internal.OperationCreate,
+		After:      newEvent,
+	}, &internal.EventMapping{SrcCalendarID: src.ID, SrcEventID: srcProviderID})
 	return nil
 }
 
-func (s Syncer) updateEvent(ctx context.Context, provider internal.Provider, cal *Calendar, event *Event) error {
+// updateEvent updates the event in cal, before is how it was in cal and
+// is saved to undo the operation.
+func (s Syncer) updateEvent(ctx context.Context, provider internal.Provider, cal *Calendar, before, event *Event) error {
 	logf(s.output, cal, "Updating event %s: %q on %s", event.ID, event.Summary, formatDateTime(event.StartsAt))
 
 	err := provider.UpdateEvent(ctx, cal, event)
@@ -241,20 +665,39 @@ func (s Syncer) updateEvent(ctx context.Context, provider internal.Provider, cal
 		logf(s.output, cal, "Unable to update event on the provider %s: %v", event.ID, err)
 		return err
 	}
+
+	after := *event
+	err = write(ctx, func(ctx context.Context) error {
+		return s.storage.SaveMirror(ctx, cal, &after)
+	})
+	if err != nil {
+		logf(s.output, cal, "Unable to save event on the storage %s: %v", event.ID, err)
+	}
+	s.audit(ctx, &internal.AuditEntry{
+		CalendarID: cal.ID,
+		EventID:    event.ID,
+		Kind:       internal.AuditEvent,
+		Operation:  internal.OperationUpdate,
+		Before:     before,
+		After:      &after,
+	}, s.mapping(ctx, cal, event.ID))
 	return nil
 }
 
-func (s Syncer) ignoreEvent(e *Event) bool {
-	if s.IgnoreDeclinedEvents && e.ResponseStatus == internal.Declined {
+// ignoreEvent checks if the event should not be mirrored, taking into
+// account the options of the syncer and of the link.
+func (s Syncer) ignoreEvent(link *Link, e *Event) bool {
+	opts := link.Options
+	if (s.IgnoreDeclinedEvents || opts.IgnoreDeclinedEvents) && e.ResponseStatus == internal.Declined {
 		return true
 	}
-	if s.IgnoreMyEventsAlone && e.CreatedByMe && e.NumAttendees == 0 {
+	if (s.IgnoreMyEventsAlone || opts.IgnoreMyEventsAlone) && e.CreatedByMe && e.NumAttendees == 0 {
 		return true
 	}
-	if s.IgnoreOutOfOfficeEvent && e.Type == internal.EventTypeOutOfOffice {
+	if (s.IgnoreOutOfOfficeEvent || opts.IgnoreOutOfOfficeEvent) && e.Type == internal.EventTypeOutOfOffice {
 		return true
 	}
-	if s.IgnoreFocusTimeEvent && e.Type == internal.EventTypeFocusTime {
+	if (s.IgnoreFocusTimeEvent || opts.IgnoreFocusTimeEvent) && e.Type == internal.EventTypeFocusTime {
 		return true
 	}
 	return false
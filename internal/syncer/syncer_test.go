@@ -0,0 +1,168 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/guilherme-santos/synccalendar/internal"
+)
+
+func TestSyncCalendarInvalidSyncToken(t *testing.T) {
+	ctx := context.Background()
+	src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+	link := &Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive}
+	storage := newFakeStorage(link)
+	provider := newFakeProvider()
+	provider.add(src, testEvent("s1", "Standup", 9))
+	provider.add(src, testEvent("s2", "Review", 11))
+	s := newTestSyncer(storage, provider)
+
+	if _, err := s.SyncCalendar(ctx, link, internal.Date{}); err != nil {
+		t.Fatalf("SyncCalendar() = %v", err)
+	}
+	if got := len(provider.list(dst)); got != 2 {
+		t.Fatalf("got %d mirror(s), want 2", got)
+	}
+
+	// The token expired while s2 was deleted.
+	delete(provider.events[src.ID], "s2")
+	provider.invalidToken = true
+	link.LastSync = "expired"
+
+	report, err := s.SyncCalendar(ctx, link, internal.Date{})
+	if err != nil {
+		t.Fatalf("SyncCalendar() = %v", err)
+	}
+	if report.Deleted != 1 || report.Updated != 1 || report.Failed != 0 {
+		t.Errorf("got %d deleted, %d updated and %d failed, want 1, 1 and 0", report.Deleted, report.Updated, report.Failed)
+	}
+	mirrors := provider.list(dst)
+	if len(mirrors) != 1 || mirrors[0].Origin.EventID != "s1" {
+		t.Fatalf("got mirrors %+v, want only the one of s1", mirrors)
+	}
+	if id, _ := storage.DestinationEventID(ctx, dst, src, "s2"); id != "" {
+		t.Errorf("mapping of s2 = %s, want it deleted", id)
+	}
+	if storage.links[0].LastSync != "token" {
+		t.Errorf("LastSync = %q, want the token of the full sync", storage.links[0].LastSync)
+	}
+}
+
+func TestSyncForceDedup(t *testing.T) {
+	ctx := context.Background()
+	work, home := testCalendar("alice", "work"), testCalendar("bob", "home")
+	dst := testCalendar("alice", "personal")
+	dst.Mode = internal.CalendarModeDedup
+	storage := newFakeStorage(
+		&Link{ID: 1, Source: work, Destination: dst, Status: internal.LinkActive},
+		&Link{ID: 2, Source: home, Destination: dst, Status: internal.LinkActive},
+	)
+	provider := newFakeProvider()
+	for _, src := range []*Calendar{work, home} {
+		event := testEvent("s1", "Planning", 9)
+		event.ICalUID = "planning"
+		provider.add(src, event)
+	}
+	provider.add(dst, testEvent("mine", "Dentist", 15))
+	s := newTestSyncer(storage, provider)
+
+	if _, err := s.Sync(ctx, nil, false, internal.Date{}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	mirrors := provider.list(dst)
+	if len(mirrors) != 2 {
+		t.Fatalf("got %d event(s), want the mirror and the event not created by us", len(mirrors))
+	}
+	before, _ := storage.EventSourceID(ctx, dst, work, "s1")
+
+	report, err := s.Sync(ctx, nil, true, internal.Date{})
+	if err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if report.Failed() {
+		t.Fatalf("Sync() failed: %v", reportErrors(report))
+	}
+	if provider.events[dst.ID]["mine"] == nil {
+		t.Errorf("event not created by us was deleted")
+	}
+	if provider.events[dst.ID][before] != nil {
+		t.Errorf("mirror %s was kept, want it deleted", before)
+	}
+	after, _ := storage.EventSourceID(ctx, dst, work, "s1")
+	if after == "" || after == before || provider.events[dst.ID][after] == nil {
+		t.Errorf("mirror of s1 = %q, want it created again", after)
+	}
+	if other, _ := storage.EventSourceID(ctx, dst, home, "s1"); other != after {
+		t.Errorf("mirror of s1 from %s = %q, want %q", home, other, after)
+	}
+	if got := len(provider.list(dst)); got != 2 {
+		t.Errorf("got %d event(s), want 2", got)
+	}
+}
+
+func reportErrors(report *SyncReport) []string {
+	var errs []string
+	for _, l := range report.Links {
+		errs = append(errs, l.Errors...)
+	}
+	return errs
+}
+
+func TestSyncForceFailure(t *testing.T) {
+	ctx := context.Background()
+	src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+	storage := newFakeStorage(&Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive})
+	provider := newFakeProvider()
+	provider.add(src, testEvent("s1", "Standup", 9))
+	provider.add(src, testEvent("s2", "Review", 11))
+	s := newTestSyncer(storage, provider)
+
+	if _, err := s.Sync(ctx, nil, false, internal.Date{}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	failed, _ := storage.DestinationEventID(ctx, dst, src, "s1")
+	provider.fail[failed] = true
+
+	report, err := s.Sync(ctx, nil, true, internal.Date{})
+	if err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if !report.Failed() {
+		t.Fatalf("Failed() = false, want true")
+	}
+	deleted := report.Links[0]
+	if deleted.LinkID != 0 || deleted.Destination != dst.ID {
+		t.Errorf("got report of link %d -> %s, want the forced delete of %s", deleted.LinkID, deleted.Destination, dst)
+	}
+	if deleted.Deleted != 1 || deleted.Failed != 1 {
+		t.Errorf("got %d deleted and %d failed, want 1 and 1", deleted.Deleted, deleted.Failed)
+	}
+}
+
+func TestSyncPageNotSaved(t *testing.T) {
+	ctx := context.Background()
+	src, dst := testCalendar("alice", "work"), testCalendar("alice", "personal")
+	dst.Mode = internal.CalendarModeDedup
+	link := &Link{ID: 1, Source: src, Destination: dst, Status: internal.LinkActive}
+	storage := newFakeStorage(link)
+	provider := newFakeProvider()
+	provider.add(src, testEvent("s1", "Standup", 9))
+	provider.add(src, testEvent("s2", "Review", 11))
+	provider.fail["s2"] = true
+	storage.failBatch = true
+	s := newTestSyncer(storage, provider)
+
+	report, err := s.SyncCalendar(ctx, link, internal.Date{})
+	if err != nil {
+		t.Fatalf("SyncCalendar() = %v", err)
+	}
+	if len(report.Errors) == 0 {
+		t.Errorf("got no errors, want the page not saved")
+	}
+	if got := provider.list(dst); len(got) != 0 {
+		t.Errorf("got mirrors %+v, want them deleted as the page wasn't saved", got)
+	}
+	if len(storage.sources) != 0 || len(storage.retries) != 0 {
+		t.Errorf("got %d source(s) and %d retries, want none saved", len(storage.sources), len(storage.retries))
+	}
+}